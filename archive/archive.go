@@ -0,0 +1,531 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Zstd
+)
+
+const (
+	// 镜像层中用.wh.前缀的空文件表示删除了下层的同名文件
+	WhiteoutPrefix = ".wh."
+	// 目录下有这个文件表示该目录是opaque的，下层同名目录的内容全部不可见
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+
+	overlayXattrPrefix = "trusted.overlay."
+	overlayOpaqueXattr = overlayXattrPrefix + "opaque"
+	paxXattrPrefix     = "SCHILY.xattr."
+
+	progressStep = 16 << 20
+)
+
+type Options struct {
+	Compression Compression
+	// 打包时把overlay upperdir中的whiteout(设备号为0/0的字符设备)和opaque目录转换成.wh.文件，
+	// 解包时再把.wh.文件还原成overlay的格式，用于在镜像层和overlay目录之间转换
+	// 不设置时解包会直接删除被.wh.标记的文件，相当于把这一层合并到dest上
+	OverlayWhiteout bool
+	// 解包时不修改文件属主，非root用户解包时使用
+	NoLchown bool
+	// 进度回调，参数为目前为止处理的字节数，打包时是tar流的大小，解包时是读到的(可能是压缩的)数据大小
+	Progress func(current int64)
+}
+
+// 把srcPath目录下的内容打包写入w，包中的路径都是相对srcPath的
+// 会保留文件属主、权限、xattr、设备文件和硬链接
+func Tar(srcPath string, w io.Writer, opts *Options) error {
+	return tarPath(srcPath, "", w, opts)
+}
+
+// 把srcPath本身打包写入w，包中的路径以name开头，srcPath是目录时包括其中的内容
+// srcPath是符号链接时打包链接本身
+func TarPath(srcPath string, name string, w io.Writer, opts *Options) error {
+	return tarPath(srcPath, name, w, opts)
+}
+
+func tarPath(srcPath string, name string, w io.Writer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	cw, err := compressWriter(w, opts.Compression)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(&progressWriter{w: cw, progress: opts.Progress})
+	ta := &tarAppender{
+		tw:         tw,
+		seenInodes: map[inode]string{},
+		overlay:    opts.OverlayWhiteout,
+	}
+	err = filepath.Walk(srcPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+		if name != "" {
+			relPath = filepath.Join(name, relPath)
+		} else if relPath == "." {
+			return nil
+		}
+		return ta.addFile(path, relPath, info)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+type tarAppender struct {
+	tw *tar.Writer
+	// 记录已经打包过的多链接inode，再次遇到时写成硬链接
+	seenInodes map[inode]string
+	overlay    bool
+}
+
+func (ta *tarAppender) addFile(path string, name string, fi os.FileInfo) error {
+	if ta.overlay && isOverlayWhiteout(fi) {
+		dir, base := filepath.Split(name)
+		return ta.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     dir + WhiteoutPrefix + base,
+			Mode:     0600,
+			ModTime:  fi.ModTime().Truncate(time.Second),
+		})
+	}
+
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	// 不带宿主机上的用户名和访问时间，保证同样的内容打包结果一致
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+	opaque := false
+	for key, value := range xattrs {
+		if ta.overlay && strings.HasPrefix(key, overlayXattrPrefix) {
+			opaque = opaque || (key == overlayOpaqueXattr && string(value) == "y")
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[paxXattrPrefix+key] = string(value)
+	}
+
+	if fi.Mode().IsRegular() {
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+			key := inode{dev: uint64(st.Dev), ino: st.Ino}
+			if first, ok := ta.seenInodes[key]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				ta.seenInodes[key] = name
+			}
+		}
+	}
+
+	if err := ta.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if opaque {
+		if err := ta.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.Join(name, WhiteoutOpaqueDir),
+			Mode:     0600,
+			ModTime:  hdr.ModTime,
+		}); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(ta.tw, file)
+	return err
+}
+
+// 把r中的tar包解压到dest，r可以是gzip压缩过的
+// 包中的路径、硬链接目标以及已解压出的符号链接都在dest内解析，恶意的tar包无法写到dest之外
+func Untar(r io.Reader, dest string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	dr, err := DecompressStream(&progressReader{r: r, progress: opts.Progress})
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	u := &unpacker{
+		dest:     dest,
+		opts:     opts,
+		unpacked: map[string]bool{},
+	}
+	tr := tar.NewReader(dr)
+	var dirs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// 先把名字当作绝对路径清理一遍，去掉开头的./和多余的..
+		name := filepath.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		parent, base := filepath.Split(name)
+		parentPath, err := SecureJoin(dest, parent)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parentPath, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(parentPath, base)
+
+		if strings.HasPrefix(base, WhiteoutPrefix) {
+			if err := u.whiteout(parentPath, parent, base); err != nil {
+				return fmt.Errorf("apply whiteout %s error %v", hdr.Name, err)
+			}
+			continue
+		}
+		if fi, err := os.Lstat(path); err == nil {
+			if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+			}
+		}
+		if err := u.createTarFile(path, hdr, tr); err != nil {
+			return fmt.Errorf("extract %s error %v", hdr.Name, err)
+		}
+		u.unpacked[name] = true
+		if hdr.Typeflag == tar.TypeDir {
+			dirHdr := *hdr
+			dirHdr.Name = path
+			dirs = append(dirs, &dirHdr)
+		}
+	}
+	// 往目录中写文件会改变目录的修改时间，所以目录的时间最后再设置
+	for _, hdr := range dirs {
+		if err := os.Chtimes(hdr.Name, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type unpacker struct {
+	dest string
+	opts *Options
+	// 本次解压出来的文件，opaque目录只清理不在其中的文件
+	unpacked map[string]bool
+}
+
+func (u *unpacker) whiteout(parentPath string, parentName string, base string) error {
+	if base == WhiteoutOpaqueDir {
+		if u.opts.OverlayWhiteout {
+			return unix.Lsetxattr(parentPath, overlayOpaqueXattr, []byte("y"), 0)
+		}
+		entries, err := ioutil.ReadDir(parentPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !u.unpacked[filepath.Join(parentName, entry.Name())] {
+				if err := os.RemoveAll(filepath.Join(parentPath, entry.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	original := strings.TrimPrefix(base, WhiteoutPrefix)
+	if original == "" || original == "." || original == ".." {
+		return fmt.Errorf("invalid whiteout name %s", base)
+	}
+	target := filepath.Join(parentPath, original)
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	if u.opts.OverlayWhiteout {
+		return unix.Mknod(target, unix.S_IFCHR, int(unix.Mkdev(0, 0)))
+	}
+	return nil
+}
+
+func (u *unpacker) createTarFile(path string, hdr *tar.Header, r io.Reader) error {
+	mode := hdr.FileInfo().Mode()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if fi, err := os.Lstat(path); err != nil || !fi.IsDir() {
+			if err := os.Mkdir(path, mode.Perm()); err != nil {
+				return err
+			}
+		}
+	case tar.TypeReg:
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, r)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeBlock, tar.TypeChar:
+		devMode := uint32(hdr.Mode & 07777)
+		if hdr.Typeflag == tar.TypeBlock {
+			devMode |= unix.S_IFBLK
+		} else {
+			devMode |= unix.S_IFCHR
+		}
+		if err := unix.Mknod(path, devMode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))); err != nil {
+			return err
+		}
+	case tar.TypeFifo:
+		if err := unix.Mkfifo(path, uint32(hdr.Mode&07777)); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		// 硬链接的目标同样在dest内解析
+		linkName := filepath.Clean("/" + hdr.Linkname)
+		linkParent, linkBase := filepath.Split(linkName)
+		linkParentPath, err := SecureJoin(u.dest, linkParent)
+		if err != nil {
+			return err
+		}
+		// 硬链接和目标共用inode，属性已经在目标上设置过了
+		return os.Link(filepath.Join(linkParentPath, linkBase), path)
+	case tar.TypeSymlink:
+		// 链接内容原样保留，后续条目的路径都经过SecureJoin解析，不会顺着它跳出dest
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeXGlobalHeader:
+		return nil
+	default:
+		return fmt.Errorf("unhandled tar header type %d", hdr.Typeflag)
+	}
+
+	if !u.opts.NoLchown {
+		if err := unix.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		xattr := strings.TrimPrefix(key, paxXattrPrefix)
+		if err := unix.Lsetxattr(path, xattr, []byte(value), 0); err != nil {
+			// 文件系统不支持或者没有权限设置trusted.*等xattr时忽略
+			if err == unix.ENOTSUP || err == unix.EPERM {
+				log.Debugf("ignore xattr %s on %s: %v", xattr, path, err)
+				continue
+			}
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		ts := []unix.Timespec{unix.NsecToTimespec(hdr.ModTime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+		return unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW)
+	}
+	// chown会清除setuid位，所以chmod要放在chown之后
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		return os.Chtimes(path, hdr.ModTime, hdr.ModTime)
+	}
+	return nil
+}
+
+func DetectCompression(source []byte) Compression {
+	switch {
+	case bytes.HasPrefix(source, []byte{0x1f, 0x8b}):
+		return Gzip
+	case bytes.HasPrefix(source, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return Zstd
+	}
+	return Uncompressed
+}
+
+// 根据数据开头的magic number判断压缩格式，返回解压后的数据流
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch DetectCompression(magic) {
+	case Gzip:
+		return gzip.NewReader(br)
+	case Zstd:
+		return nil, fmt.Errorf("zstd compressed archive is not supported")
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+func compressWriter(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case Uncompressed:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %d", compression)
+}
+
+// 返回一个进度回调，每处理16MB打印一次日志
+func LogProgress(action string) func(int64) {
+	var next int64 = progressStep
+	return func(current int64) {
+		if current >= next {
+			log.Infof("%s: %d MB", action, current>>20)
+			next = current + progressStep
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type progressWriter struct {
+	w        io.Writer
+	current  int64
+	progress func(int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.current += int64(n)
+	if p.progress != nil {
+		p.progress(p.current)
+	}
+	return n, err
+}
+
+// 读r时按读到的字节数回调progress
+func ProgressReader(r io.Reader, progress func(int64)) io.Reader {
+	return &progressReader{r: r, progress: progress}
+}
+
+type progressReader struct {
+	r        io.Reader
+	current  int64
+	progress func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.current += int64(n)
+	if p.progress != nil {
+		p.progress(p.current)
+	}
+	return n, err
+}
+
+func isOverlayWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, err
+	}
+	xattrs := map[string][]byte{}
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		valueSize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = unix.Lgetxattr(path, name, value); err != nil {
+			return nil, err
+		}
+		xattrs[name] = value[:valueSize]
+	}
+	return xattrs, nil
+}
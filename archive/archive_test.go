@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestTarUntar(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "etc"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "etc", "hostname"), []byte("mydocker"), 0644)
+	os.Link(filepath.Join(src, "etc", "hostname"), filepath.Join(src, "hostname"))
+	os.Symlink("etc/hostname", filepath.Join(src, "link"))
+
+	var buf bytes.Buffer
+	if err := Tar(src, &buf, &Options{Compression: Gzip}); err != nil {
+		t.Fatalf("tar error: %v", err)
+	}
+	dest := t.TempDir()
+	if err := Untar(&buf, dest, nil); err != nil {
+		t.Fatalf("untar error: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dest, "link"))
+	if err != nil || string(content) != "mydocker" {
+		t.Fatalf("read link content: %s, error: %v", content, err)
+	}
+	fi1, _ := os.Stat(filepath.Join(dest, "etc", "hostname"))
+	fi2, _ := os.Stat(filepath.Join(dest, "hostname"))
+	if !os.SameFile(fi1, fi2) {
+		t.Fatalf("hardlink not preserved")
+	}
+}
+
+func TestUntarOverlayWhiteout(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mknod whiteout requires root")
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "dir/.wh.removed", Mode: 0600})
+	tw.Close()
+
+	dest := t.TempDir()
+	if err := Untar(&buf, dest, &Options{OverlayWhiteout: true}); err != nil {
+		t.Fatalf("untar error: %v", err)
+	}
+	fi, err := os.Lstat(filepath.Join(dest, "dir", "removed"))
+	if err != nil || !isOverlayWhiteout(fi) {
+		t.Fatalf("whiteout not converted: %v", err)
+	}
+
+	// 再打包回来应该还原成.wh.文件
+	buf.Reset()
+	if err := Tar(dest, &buf, &Options{OverlayWhiteout: true}); err != nil {
+		t.Fatalf("tar error: %v", err)
+	}
+	tr := tar.NewReader(&buf)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		found = found || hdr.Name == "dir/.wh.removed"
+	}
+	if !found {
+		t.Fatalf("whiteout not exported")
+	}
+}
+
+func TestUntarBreakout(t *testing.T) {
+	root := t.TempDir()
+	dest := filepath.Join(root, "dest")
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../escape", Mode: 0644})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "evil", Linkname: root, Mode: 0777})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "evil/through-link", Mode: 0644})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "hardlink", Linkname: "../../etc/passwd"})
+	tw.Close()
+
+	// 硬链接的目标在dest内不存在，解压应该失败，但之前的条目都不能写到dest之外
+	Untar(&buf, dest, &Options{NoLchown: true})
+	for _, name := range []string{"escape", "through-link"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+			t.Fatalf("%s written outside of dest", name)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "escape")); err != nil {
+		t.Fatalf("escape should be extracted into dest: %v", err)
+	}
+	if fi, err := os.Lstat(filepath.Join(dest, "hardlink")); err == nil {
+		if st := fi.Sys().(*syscall.Stat_t); st.Nlink > 1 {
+			t.Fatalf("hardlink to outside of dest created")
+		}
+	}
+}
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a"), 0755)
+	os.Symlink("/etc", filepath.Join(root, "a", "abs"))
+	os.Symlink("../../../..", filepath.Join(root, "a", "rel"))
+
+	cases := map[string]string{
+		"a/abs/passwd": filepath.Join(root, "etc", "passwd"),
+		"a/rel/etc":    filepath.Join(root, "etc"),
+		"../../x":      filepath.Join(root, "x"),
+		"/a/./b":       filepath.Join(root, "a", "b"),
+	}
+	for path, expected := range cases {
+		if got, err := SecureJoin(root, path); err != nil || got != expected {
+			t.Errorf("SecureJoin(%s) = %s, %v, want %s", path, got, err, expected)
+		}
+	}
+}
+
+func TestTarPath(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "dir", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "dir", "sub", "file"), []byte("content"), 0644)
+
+	var buf bytes.Buffer
+	if err := TarPath(filepath.Join(src, "dir"), "renamed", &buf, nil); err != nil {
+		t.Fatalf("tar error: %v", err)
+	}
+	dest := t.TempDir()
+	if err := Untar(&buf, dest, nil); err != nil {
+		t.Fatalf("untar error: %v", err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dest, "renamed", "sub", "file")); err != nil || string(content) != "content" {
+		t.Fatalf("read file content: %s, error: %v", content, err)
+	}
+}
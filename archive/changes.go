@@ -0,0 +1,282 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type ChangeKind int
+
+const (
+	ChangeModify ChangeKind = iota
+	ChangeAdd
+	ChangeDelete
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdd:
+		return "A"
+	case ChangeDelete:
+		return "D"
+	}
+	return "C"
+}
+
+// 容器rootfs中的一处修改，Path是rootfs内以/开头的路径
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+func (c Change) String() string {
+	return c.Kind.String() + " " + c.Path
+}
+
+// 对比rootfs和镜像各层合并后的内容，得到rootfs中新增、修改和删除的文件，按路径排序
+// layers是镜像各层的目录，最上层在前，层中的删除是overlay格式的whiteout和opaque目录
+// 删除的目录只报告目录本身；有文件变化的目录即使自身没变也报告为修改，打包时可以保留目录的属性
+func Changes(layers []string, rootfs string) ([]Change, error) {
+	lower, err := mergeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	kinds := map[string]ChangeKind{}
+	seen := map[string]bool{"/": true}
+	err = filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := changePath(rootfs, path)
+		if err != nil || name == "/" {
+			return err
+		}
+		seen[name] = true
+		old, ok := lower[name]
+		if !ok {
+			kinds[name] = ChangeAdd
+			return nil
+		}
+		changed, err := fileChanged(old, path, fi)
+		if err != nil {
+			return err
+		}
+		if changed {
+			kinds[name] = ChangeModify
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range lower {
+		if !seen[name] && seen[filepath.Dir(name)] {
+			kinds[name] = ChangeDelete
+		}
+	}
+	for name := range kinds {
+		for dir := filepath.Dir(name); dir != "/"; dir = filepath.Dir(dir) {
+			if _, ok := kinds[dir]; ok {
+				break
+			}
+			kinds[dir] = ChangeModify
+		}
+	}
+
+	changes := make([]Change, 0, len(kinds))
+	for name, kind := range kinds {
+		changes = append(changes, Change{Path: name, Kind: kind})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// 根据overlay的upperdir得到容器的修改，layers是镜像各层的目录，最上层在前
+// upperdir中的whiteout是删除了下层的文件；opaque目录中的下层文件都被删除了，
+// 只报告upperdir中没有同名文件的那些；upperdir中的其他文件在下层存在时是修改，否则是新增
+func OverlayChanges(layers []string, upper string) ([]Change, error) {
+	lower, err := mergeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	var opaqueDirs []string
+	err = filepath.Walk(upper, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := changePath(upper, path)
+		if err != nil || name == "/" {
+			return err
+		}
+		_, inLower := lower[name]
+		if isOverlayWhiteout(fi) {
+			if inLower {
+				changes = append(changes, Change{Path: name, Kind: ChangeDelete})
+			}
+			return nil
+		}
+		if fi.IsDir() && isOpaqueDir(path) {
+			opaqueDirs = append(opaqueDirs, name)
+		}
+		if inLower {
+			changes = append(changes, Change{Path: name, Kind: ChangeModify})
+		} else {
+			changes = append(changes, Change{Path: name, Kind: ChangeAdd})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// opaque目录下的下层文件，所在目录在upperdir中存在而文件本身不存在的被删除了，
+	// 所在目录也不存在的随目录一起删除，不再单独报告
+	deleted := map[string]bool{}
+	for _, dir := range opaqueDirs {
+		for name := range lower {
+			if !strings.HasPrefix(name, dir+"/") || deleted[name] {
+				continue
+			}
+			if _, err := os.Lstat(filepath.Join(upper, name)); !os.IsNotExist(err) {
+				continue
+			}
+			if parent, err := os.Lstat(filepath.Join(upper, filepath.Dir(name))); err == nil && parent.IsDir() {
+				deleted[name] = true
+				changes = append(changes, Change{Path: name, Kind: ChangeDelete})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// 被比较的文件和它在镜像层中的位置
+type layerFile struct {
+	path string
+	fi   os.FileInfo
+}
+
+// 从最底层开始把各层叠加起来，得到合并后每个路径对应的文件
+func mergeLayers(layers []string) (map[string]layerFile, error) {
+	merged := map[string]layerFile{}
+	removeChildren := func(name string) {
+		prefix := name + "/"
+		for child := range merged {
+			if strings.HasPrefix(child, prefix) {
+				delete(merged, child)
+			}
+		}
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		layer := layers[i]
+		err := filepath.Walk(layer, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			name, err := changePath(layer, path)
+			if err != nil || name == "/" {
+				return err
+			}
+			if isOverlayWhiteout(fi) {
+				delete(merged, name)
+				removeChildren(name)
+				return nil
+			}
+			if old, ok := merged[name]; ok && old.fi.IsDir() && !fi.IsDir() {
+				removeChildren(name)
+			}
+			if fi.IsDir() && isOpaqueDir(path) {
+				removeChildren(name)
+			}
+			merged[name] = layerFile{path: path, fi: fi}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+func changePath(root string, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join("/", rel), nil
+}
+
+func isOpaqueDir(path string) bool {
+	value := make([]byte, 1)
+	n, err := unix.Lgetxattr(path, overlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// 类型、权限、属主或者设备号不同就是修改了；目录的大小和修改时间随其中的文件变化，不参与比较
+// 从tar包解压出的文件时间只精确到秒，rootfs中的文件带有纳秒时说明是在容器中修改过的
+func fileChanged(old layerFile, path string, fi os.FileInfo) (bool, error) {
+	if old.fi.Mode() != fi.Mode() {
+		return true, nil
+	}
+	oldStat, ok1 := old.fi.Sys().(*syscall.Stat_t)
+	newStat, ok2 := fi.Sys().(*syscall.Stat_t)
+	if ok1 && ok2 && (oldStat.Uid != newStat.Uid || oldStat.Gid != newStat.Gid || oldStat.Rdev != newStat.Rdev) {
+		return true, nil
+	}
+	if fi.IsDir() {
+		return false, nil
+	}
+	oldTime, newTime := old.fi.ModTime(), fi.ModTime()
+	if old.fi.Size() != fi.Size() || oldTime.Unix() != newTime.Unix() ||
+		newTime.Nanosecond() != 0 && newTime.Nanosecond() != oldTime.Nanosecond() {
+		return true, nil
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return false, nil
+	}
+	oldLink, err := os.Readlink(old.path)
+	if err != nil {
+		return false, err
+	}
+	newLink, err := os.Readlink(path)
+	if err != nil {
+		return false, err
+	}
+	return oldLink != newLink, nil
+}
+
+// 把changes中新增和修改的文件从rootfs中打包，删除的文件写成.wh.文件，得到镜像层格式的tar
+func ExportChanges(rootfs string, changes []Change, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	ta := &tarAppender{tw: tw, seenInodes: map[inode]string{}}
+	for _, change := range changes {
+		name := strings.TrimPrefix(change.Path, "/")
+		if change.Kind == ChangeDelete {
+			dir, base := filepath.Split(name)
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     dir + WhiteoutPrefix + base,
+				Mode:     0600,
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		path := filepath.Join(rootfs, name)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if err := ta.addFile(path, name, fi); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
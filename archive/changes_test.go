@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestChanges(t *testing.T) {
+	layer := t.TempDir()
+	os.MkdirAll(filepath.Join(layer, "etc"), 0755)
+	os.MkdirAll(filepath.Join(layer, "bin"), 0755)
+	ioutil.WriteFile(filepath.Join(layer, "etc", "hostname"), []byte("mydocker"), 0644)
+	ioutil.WriteFile(filepath.Join(layer, "etc", "passwd"), []byte("root"), 0644)
+	ioutil.WriteFile(filepath.Join(layer, "bin", "sh"), []byte("sh"), 0755)
+
+	// 像vfs驱动一样把镜像层拷贝一份作为rootfs，拷贝出的内容和镜像层相同
+	copyLayer := func() string {
+		var buf bytes.Buffer
+		if err := Tar(layer, &buf, nil); err != nil {
+			t.Fatalf("tar error: %v", err)
+		}
+		rootfs := t.TempDir()
+		if err := Untar(&buf, rootfs, nil); err != nil {
+			t.Fatalf("untar error: %v", err)
+		}
+		return rootfs
+	}
+	rootfs := copyLayer()
+	if changes, err := Changes([]string{layer}, rootfs); err != nil || len(changes) != 0 {
+		t.Fatalf("unexpected changes of copied layer %v %v", changes, err)
+	}
+
+	ioutil.WriteFile(filepath.Join(rootfs, "etc", "hostname"), []byte("changed"), 0644)
+	os.Remove(filepath.Join(rootfs, "etc", "passwd"))
+	os.RemoveAll(filepath.Join(rootfs, "bin"))
+	os.MkdirAll(filepath.Join(rootfs, "new"), 0755)
+	ioutil.WriteFile(filepath.Join(rootfs, "new", "file"), []byte("new"), 0644)
+	changes, err := Changes([]string{layer}, rootfs)
+	if err != nil {
+		t.Fatalf("changes error: %v", err)
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	expected := []string{"D /bin", "C /etc", "C /etc/hostname", "D /etc/passwd", "A /new", "A /new/file"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("changes = %v, want %v", got, expected)
+	}
+
+	// 导出的修改应用到镜像层的拷贝上，应该得到同样的rootfs
+	var buf bytes.Buffer
+	if err := ExportChanges(rootfs, changes, &buf); err != nil {
+		t.Fatalf("export changes error: %v", err)
+	}
+	applied := copyLayer()
+	if err := Untar(&buf, applied, nil); err != nil {
+		t.Fatalf("apply changes error: %v", err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(applied, "etc", "hostname")); err != nil || string(content) != "changed" {
+		t.Errorf("hostname not applied: %s %v", content, err)
+	}
+	for _, name := range []string{"bin", "etc/passwd"} {
+		if _, err := os.Lstat(filepath.Join(applied, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(applied, "new", "file")); err != nil {
+		t.Errorf("new file not applied: %v", err)
+	}
+}
+
+func TestOverlayChanges(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mknod whiteout and trusted xattr require root")
+	}
+	layer := t.TempDir()
+	os.MkdirAll(filepath.Join(layer, "etc"), 0755)
+	os.MkdirAll(filepath.Join(layer, "opt", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(layer, "etc", "hostname"), []byte("mydocker"), 0644)
+	ioutil.WriteFile(filepath.Join(layer, "etc", "passwd"), []byte("root"), 0644)
+	ioutil.WriteFile(filepath.Join(layer, "opt", "kept"), []byte("lower"), 0644)
+	ioutil.WriteFile(filepath.Join(layer, "opt", "sub", "file"), []byte("lower"), 0644)
+
+	upper := t.TempDir()
+	os.MkdirAll(filepath.Join(upper, "etc"), 0755)
+	ioutil.WriteFile(filepath.Join(upper, "etc", "hostname"), []byte("changed"), 0644)
+	if err := unix.Mknod(filepath.Join(upper, "etc", "passwd"), unix.S_IFCHR, 0); err != nil {
+		t.Fatalf("mknod whiteout error: %v", err)
+	}
+	// opt被删除后重建，是opaque目录，下层中只有kept还在
+	os.MkdirAll(filepath.Join(upper, "opt"), 0755)
+	ioutil.WriteFile(filepath.Join(upper, "opt", "kept"), []byte("upper"), 0644)
+	if err := unix.Lsetxattr(filepath.Join(upper, "opt"), overlayOpaqueXattr, []byte("y"), 0); err != nil {
+		t.Skipf("set opaque xattr error: %v", err)
+	}
+	ioutil.WriteFile(filepath.Join(upper, "new"), []byte("new"), 0644)
+
+	changes, err := OverlayChanges([]string{layer}, upper)
+	if err != nil {
+		t.Fatalf("changes error: %v", err)
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	expected := []string{"C /etc", "C /etc/hostname", "D /etc/passwd", "A /new", "C /opt", "C /opt/kept", "D /opt/sub"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("changes = %v, want %v", got, expected)
+	}
+}
@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// 符号链接最多解析的次数，超过认为出现了循环
+const maxSymlinkDepth = 255
+
+// 把unsafePath当作root下的路径拼接起来，路径中的符号链接和..都在root内解析，
+// 即使链接指向绝对路径或者../../，也不会逃出root，类似chroot后再解析路径
+// 最后一个分量是符号链接时同样会被解析，不存在的部分按字面拼接
+func SecureJoin(root, unsafePath string) (string, error) {
+	current := "/"
+	linksWalked := 0
+	for unsafePath != "" {
+		var part string
+		if i := strings.IndexRune(unsafePath, '/'); i == -1 {
+			part, unsafePath = unsafePath, ""
+		} else {
+			part, unsafePath = unsafePath[:i], unsafePath[i+1:]
+		}
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			// filepath.Dir("/")仍然是"/"，所以..不会越过root
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				current = next
+				continue
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		linksWalked++
+		if linksWalked > maxSymlinkDepth {
+			return "", &os.PathError{Op: "securejoin", Path: filepath.Join(root, next), Err: syscall.ELOOP}
+		}
+		dest, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		// 绝对路径的链接相对root解析，相对路径的链接相对链接所在目录解析
+		if filepath.IsAbs(dest) {
+			current = "/"
+		}
+		unsafePath = dest + "/" + unsafePath
+	}
+	return filepath.Join(root, current), nil
+}
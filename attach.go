@@ -0,0 +1,24 @@
+package main
+
+import (
+	"example/mydocker/container"
+	"fmt"
+)
+
+// 把用户的终端接到运行中的容器上，容器退出或者用户按Ctrl-P Ctrl-Q分离后返回
+// 容器启动时没有打开标准输入的，只接收容器的输出
+func AttachContainer(containerName string, stdin bool) error {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if !containerAlive(info) {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	conn, err := container.DialAttach(containerName)
+	if err != nil {
+		return err
+	}
+	_, err = container.Attach(conn, info.Tty, stdin && info.OpenStdin)
+	return err
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"example/mydocker/archive"
+	"example/mydocker/container"
+	"example/mydocker/dockerfile"
+	"example/mydocker/paths"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type builder struct {
+	contextDir string
+	noCache    bool
+	// 当前步骤的缓存key，由上一步的key和本步的指令计算得到
+	key    string
+	config *container.ImageConfig
+	// RUN、COPY和ADD在上一步的结果保存成的镜像上执行，见image
+	imageID string
+	// 构建过程中新保存的中间镜像，构建结束后删除，构建缓存中保存的是镜像配置，不依赖它们
+	intermediates map[string]bool
+}
+
+// 根据Dockerfile构建镜像，RUN在临时容器中执行，RUN/COPY/ADD的结果提交为一个镜像层
+func BuildImage(contextDir string, dockerfilePath string, imageName string, noCache bool) error {
+	ref, err := container.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+	if dockerfilePath == "" {
+		dockerfilePath = filepath.Join(contextDir, "Dockerfile")
+	}
+	file, err := os.Open(dockerfilePath)
+	if err != nil {
+		return fmt.Errorf("open dockerfile %s error %v", dockerfilePath, err)
+	}
+	instructions, err := dockerfile.Parse(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("parse dockerfile error %v", err)
+	}
+
+	b := &builder{
+		contextDir:    contextDir,
+		noCache:       noCache,
+		config:        &container.ImageConfig{},
+		intermediates: map[string]bool{},
+	}
+	defer b.removeIntermediates()
+	for i, instruction := range instructions {
+		fmt.Printf("Step %d/%d : %s\n", i+1, len(instructions), instruction)
+		if err := b.dispatch(instruction); err != nil {
+			return fmt.Errorf("step %d %s error: %v", i+1, instruction.Cmd, err)
+		}
+	}
+	imageID, err := b.image()
+	if err != nil {
+		return err
+	}
+	delete(b.intermediates, imageID)
+	if err := container.TagImage(imageID, ref); err != nil {
+		return fmt.Errorf("tag image %s error %v", ref, err)
+	}
+	fmt.Printf("Successfully built %s\n", imageID[:12])
+	fmt.Printf("Successfully tagged %s\n", ref)
+	return nil
+}
+
+func (b *builder) dispatch(instruction *dockerfile.Instruction) error {
+	switch instruction.Cmd {
+	case "FROM":
+		return b.from(instruction)
+	case "RUN":
+		args := shellForm(instruction)
+		return b.commitStep(instruction, "", func(containerName string) error {
+			return b.runInContainer(containerName, args)
+		})
+	case "COPY", "ADD":
+		return b.copy(instruction)
+	}
+
+	// 其余指令只修改镜像配置，不产生新的镜像层
+	switch instruction.Cmd {
+	case "ENV":
+		pairs, err := dockerfile.ParseKeyValues(instruction)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			b.config.Env = setEnv(b.config.Env, pair[0], pair[1])
+		}
+	case "LABEL":
+		pairs, err := dockerfile.ParseKeyValues(instruction)
+		if err != nil {
+			return err
+		}
+		if b.config.Labels == nil {
+			b.config.Labels = map[string]string{}
+		}
+		for _, pair := range pairs {
+			b.config.Labels[pair[0]] = pair[1]
+		}
+	case "WORKDIR":
+		b.config.WorkingDir = b.resolvePath(instruction.Raw)
+	case "CMD":
+		b.config.Cmd = shellForm(instruction)
+	case "ENTRYPOINT":
+		b.config.Entrypoint = shellForm(instruction)
+	}
+	b.key = cacheKey(b.key, instruction.String(), "")
+	return b.saveStep()
+}
+
+func (b *builder) from(instruction *dockerfile.Instruction) error {
+	baseImage := instruction.Args[0]
+	b.config = &container.ImageConfig{}
+	if baseImage == "scratch" {
+		// overlay至少需要一个lowerdir，空镜像使用一个空的镜像层
+		layer, err := createEmptyLayer()
+		if err != nil {
+			return fmt.Errorf("create empty layer error %v", err)
+		}
+		b.config.Layers = []string{layer}
+		b.key = cacheKey("", "FROM scratch", "")
+	} else {
+		baseID, err := container.ResolveImage(baseImage)
+		if err != nil {
+			return err
+		}
+		if b.config, err = container.ReadImageConfig(baseID); err != nil {
+			return err
+		}
+		b.key = cacheKey("", "FROM "+baseID, "")
+	}
+	return b.saveStep()
+}
+
+func createEmptyLayer() (string, error) {
+	if err := os.MkdirAll(paths.Root(), 0777); err != nil {
+		return "", err
+	}
+	emptyDir, err := ioutil.TempDir(paths.Root(), "scratch-")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(emptyDir, 0755); err != nil {
+		return "", err
+	}
+	return container.CreateLayerFromDir(emptyDir)
+}
+
+// 在上一步镜像的基础上创建临时容器的workspace，由mutate修改容器的文件系统，
+// 然后把容器的读写层提交为新的镜像层；命中缓存时直接使用缓存的结果
+func (b *builder) commitStep(instruction *dockerfile.Instruction, contentHash string, mutate func(containerName string) error) error {
+	key := cacheKey(b.key, instruction.String(), contentHash)
+	if !b.noCache {
+		if config, ok := lookupBuildCache(key); ok {
+			b.key, b.config = key, config
+			imageID, err := container.ImageID(config)
+			if err != nil {
+				return err
+			}
+			fmt.Printf(" ---> Using cache %s\n", imageID[:12])
+			return nil
+		}
+	}
+	if _, err := b.image(); err != nil {
+		return err
+	}
+
+	driver, err := container.GetStorageDriver(paths.StorageDriver())
+	if err != nil {
+		return err
+	}
+	// 临时容器没有信息文件，构建这一步期间持有锁，防止workspace被prune删掉
+	lock, err := lockPrune(false)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	containerName := "build-" + randStringBytes(10)
+	defer deleteContainerInfo(containerName)
+	defer driver.Remove(containerName)
+	err = mutate(containerName)
+	// 先卸载rootfs，再把容器的修改作为新的镜像层
+	if umountErr := driver.Unmount(containerName); umountErr != nil && err == nil {
+		err = umountErr
+	}
+	if err != nil {
+		return err
+	}
+	diff, err := driver.Diff(containerName, b.imageID)
+	if err != nil {
+		return err
+	}
+	layer, err := container.CreateLayerFromTar(diff)
+	diff.Close()
+	if err != nil {
+		return fmt.Errorf("create layer error %v", err)
+	}
+	b.config.Layers = append(b.config.Layers, layer)
+	b.key = key
+	if err := b.saveStep(); err != nil {
+		return err
+	}
+	imageID, err := container.ImageID(b.config)
+	if err != nil {
+		return err
+	}
+	fmt.Printf(" ---> %s\n", imageID[:12])
+	return nil
+}
+
+func (b *builder) runInContainer(containerName string, args []string) error {
+	parent, writePipe, err := container.NewParentProcess(false, nil, containerName, b.imageID, b.config.Env, nil)
+	if err != nil {
+		return err
+	}
+	// 构建的输出直接打印出来，不写到容器日志中
+	parent.Stdout = os.Stdout
+	parent.Stderr = os.Stderr
+	if err := parent.Start(); err != nil {
+		return err
+	}
+	if err := sendInitCommand(parent, &container.InitConfig{Args: args, WorkingDir: b.config.WorkingDir}, writePipe); err != nil {
+		parent.Wait()
+		return fmt.Errorf("start container error %v", err)
+	}
+	if err := parent.Wait(); err != nil {
+		return fmt.Errorf("command '%s' returned error: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// COPY和ADD把构建上下文中的文件拷贝到临时容器的rootfs中，ADD还支持URL和自动解压本地的tar包
+func (b *builder) copy(instruction *dockerfile.Instruction) error {
+	args := instruction.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%s requires at least two arguments", instruction.Cmd)
+	}
+	isAdd := instruction.Cmd == "ADD"
+	rawDest := args[len(args)-1]
+	dest := b.resolvePath(rawDest)
+	// 目标以/结尾、是.或者有多个来源时，目标是一个目录
+	destIsDir := strings.HasSuffix(rawDest, "/") || path.Base(rawDest) == "." || len(args) > 2
+
+	var sources []string
+	hasher := sha256.New()
+	for _, src := range args[:len(args)-1] {
+		if isAdd && isRemoteURL(src) {
+			sources = append(sources, src)
+			io.WriteString(hasher, src)
+			continue
+		}
+		matches, err := b.contextGlob(src)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := hashSource(hasher, match); err != nil {
+				return err
+			}
+		}
+		sources = append(sources, matches...)
+	}
+	if len(sources) > 1 {
+		destIsDir = true
+	}
+
+	return b.commitStep(instruction, hex.EncodeToString(hasher.Sum(nil)), func(containerName string) error {
+		if err := container.NewWorkSpace(containerName, b.imageID, nil); err != nil {
+			return err
+		}
+		mntURL := paths.MntUrl(containerName)
+		// 在容器的rootfs内解析目标路径，rootfs中的符号链接不会指到宿主机上
+		destPath, err := archive.SecureJoin(mntURL, dest)
+		if err != nil {
+			return err
+		}
+		if fi, err := os.Stat(destPath); err == nil && fi.IsDir() {
+			destIsDir = true
+		}
+		for _, src := range sources {
+			if err := copySource(src, mntURL, dest, destIsDir, isAdd); err != nil {
+				return fmt.Errorf("copy %s error %v", src, err)
+			}
+		}
+		return nil
+	})
+}
+
+// 在构建上下文中查找文件，支持通配符，路径不能跳出上下文目录
+func (b *builder) contextGlob(src string) ([]string, error) {
+	srcPath, err := archive.SecureJoin(b.contextDir, src)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s not found in build context", src)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// 把src拷贝到rootfs中的dest，dest是容器中的路径，写入的每个路径都在rootfs内解析
+func copySource(src string, rootfs string, dest string, destIsDir bool, isAdd bool) error {
+	if isAdd && isRemoteURL(src) {
+		target := dest
+		if destIsDir {
+			target = path.Join(dest, path.Base(src))
+		}
+		targetPath, err := resolveTarget(rootfs, target)
+		if err != nil {
+			return err
+		}
+		return downloadFile(src, targetPath)
+	}
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	destPath, err := archive.SecureJoin(rootfs, dest)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return copyDir(src, destPath)
+	}
+	if isAdd {
+		if ok, err := untarIfArchive(src, destPath); ok || err != nil {
+			return err
+		}
+	}
+	target := dest
+	if destIsDir {
+		target = path.Join(dest, filepath.Base(src))
+	}
+	targetPath, err := resolveTarget(rootfs, target)
+	if err != nil {
+		return err
+	}
+	return copyFile(src, targetPath, fi.Mode())
+}
+
+// 在rootfs内解析容器中的路径target，并创建它所在的目录，返回宿主机上的路径
+// 解析出的路径中已经存在的部分都不是符号链接，MkdirAll只会创建新的目录
+func resolveTarget(rootfs string, target string) (string, error) {
+	targetPath, err := archive.SecureJoin(rootfs, target)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return "", err
+	}
+	return targetPath, nil
+}
+
+// 目录只拷贝其中的内容，文件属主改为root
+func copyDir(src string, dest string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Tar(src, pw, nil))
+	}()
+	err := archive.Untar(pr, dest, &archive.Options{NoLchown: true})
+	pr.CloseWithError(err)
+	return err
+}
+
+// 目标已经在rootfs内解析过，O_NOFOLLOW保证不会再顺着符号链接写到rootfs之外
+func createFile(dest string, mode os.FileMode) (*os.File, error) {
+	return os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|syscall.O_NOFOLLOW, mode.Perm())
+}
+
+func copyFile(src string, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createFile(dest, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// 本地文件是(压缩的)tar包时解压到目标目录，返回是否是tar包
+func untarIfArchive(src string, dest string) (bool, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	r, err := archive.DecompressStream(file)
+	if err != nil {
+		return false, nil
+	}
+	_, err = tar.NewReader(r).Next()
+	r.Close()
+	if err != nil {
+		return false, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return true, err
+	}
+	return true, archive.Untar(file, dest, nil)
+}
+
+func downloadFile(url string, dest string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s error: %s", url, resp.Status)
+	}
+	out, err := createFile(dest, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func isRemoteURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// 来源文件的内容也要计入缓存key，文件改动后缓存失效
+func hashSource(hasher hash.Hash, src string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(hasher, "%s %v\n", filepath.Base(src), fi.Mode())
+	if fi.IsDir() {
+		return archive.Tar(src, hasher, nil)
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(hasher, file)
+	return err
+}
+
+// 在构建缓存中记录缓存key对应的镜像配置，只修改配置的指令不需要保存镜像
+func (b *builder) saveStep() error {
+	jsonBytes, err := json.Marshal(b.config)
+	if err != nil {
+		return err
+	}
+	cacheURL := paths.BuildCacheUrl(b.key)
+	if err := os.MkdirAll(filepath.Dir(cacheURL), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cacheURL, jsonBytes, 0644)
+}
+
+// 把当前的镜像配置保存成镜像，RUN、COPY和ADD的临时容器以及最后的构建结果使用
+// 同样的配置已经有镜像时直接使用，这次新保存的记为中间镜像
+func (b *builder) image() (string, error) {
+	imageID, created, err := container.SaveImage(b.config)
+	if err != nil {
+		return "", err
+	}
+	if created {
+		b.intermediates[imageID] = true
+	}
+	b.imageID = imageID
+	return imageID, nil
+}
+
+// 删除中间镜像，它们的镜像层都在最后的镜像中，不会被删除
+func (b *builder) removeIntermediates() {
+	for imageID := range b.intermediates {
+		if err := container.DeleteImage(imageID); err != nil {
+			log.Warnf("remove intermediate image %s error %v", imageID[:12], err)
+		}
+	}
+}
+
+// 相对路径相对于当前的WORKDIR
+func (b *builder) resolvePath(p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	workDir := b.config.WorkingDir
+	if workDir == "" {
+		workDir = "/"
+	}
+	return path.Join(workDir, p)
+}
+
+func lookupBuildCache(key string) (*container.ImageConfig, bool) {
+	content, err := ioutil.ReadFile(paths.BuildCacheUrl(key))
+	if err != nil {
+		return nil, false
+	}
+	config := &container.ImageConfig{}
+	// 以前的缓存中保存的是中间镜像的id，不再使用
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, false
+	}
+	// 缓存的镜像层可能已经被删除了
+	for _, layer := range config.Layers {
+		if _, err := os.Stat(paths.LayerUrl(layer)); err != nil {
+			return nil, false
+		}
+	}
+	return config, true
+}
+
+func cacheKey(parentKey string, instruction string, contentHash string) string {
+	sum := sha256.Sum256([]byte(parentKey + "\n" + instruction + "\n" + contentHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// RUN、CMD、ENTRYPOINT的shell格式通过/bin/sh -c执行
+func shellForm(instruction *dockerfile.Instruction) []string {
+	if instruction.JSON {
+		return instruction.Args
+	}
+	return []string{"/bin/sh", "-c", instruction.Raw}
+}
+
+func setEnv(environment []string, key string, value string) []string {
+	for i, env := range environment {
+		if strings.HasPrefix(env, key+"=") {
+			environment[i] = key + "=" + value
+			return environment
+		}
+	}
+	return append(environment, key+"="+value)
+}
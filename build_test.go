@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 镜像中指向宿主机绝对路径的符号链接只能在rootfs内解析，COPY不能写到rootfs之外
+func TestCopySourceSymlinkDest(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	rootfs := filepath.Join(tmp, "rootfs")
+	outside := filepath.Join(tmp, "outside")
+	for _, dir := range []string{filepath.Join(rootfs, "app"), filepath.Join(rootfs, "etc"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	victim := filepath.Join(outside, "victim")
+	if err := ioutil.WriteFile(victim, []byte("host"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"app/dir":    outside,
+		"app/a.txt":  victim,
+		"etc/passwd": victim,
+		"etc/sub":    outside,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(rootfs, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := filepath.Join(tmp, "a.txt")
+	if err := ioutil.WriteFile(src, []byte("build"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dest      string
+		destIsDir bool
+		// 文件在rootfs中实际写到的位置
+		expect string
+	}{
+		{"/app/dir/", true, filepath.Join(outside, "a.txt")},
+		{"/app/", true, victim},
+		{"/etc/passwd", false, victim},
+		{"/etc/sub/new/a.txt", false, filepath.Join(outside, "new", "a.txt")},
+	}
+	for _, test := range tests {
+		if err := copySource(src, rootfs, test.dest, test.destIsDir, false); err != nil {
+			t.Errorf("copy to %s error %v", test.dest, err)
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(rootfs, test.expect))
+		if err != nil || string(content) != "build" {
+			t.Errorf("copy to %s: expect file in rootfs at %s, got %q %v", test.dest, test.expect, content, err)
+		}
+	}
+	if content, _ := ioutil.ReadFile(victim); string(content) != "host" {
+		t.Errorf("file outside rootfs was overwritten: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("file created outside rootfs: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Errorf("directory created outside rootfs: %v", err)
+	}
+}
@@ -2,6 +2,13 @@ package cgroups
 
 import (
 	"example/mydocker/cgroups/subsystems"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -17,25 +24,176 @@ func NewCgroupManager(path string) *CgroupManager {
 	}
 }
 
+// 每个子系统都尝试加入，失败的子系统通过SubsystemErrors返回
 func (c *CgroupManager) Apply(pid int) error {
+	errs := SubsystemErrors{}
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Apply(c.Path, pid)
+		if err := subSysIns.Apply(c.Path, pid); err != nil {
+			errs[subSysIns.Name()] = err
+		}
 	}
-	return nil
+	return errs.err()
 }
 
+// 每个子系统都尝试设置，失败的子系统通过SubsystemErrors返回
 func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
+	errs := SubsystemErrors{}
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Set(c.Path, res)
+		if err := subSysIns.Set(c.Path, res); err != nil {
+			errs[subSysIns.Name()] = err
+		}
+	}
+	return errs.err()
+}
+
+// 设置或者加入cgroup失败的子系统和对应的错误
+type SubsystemErrors map[string]error
+
+func (e SubsystemErrors) Error() string {
+	var names []string
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var msgs []string
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("cgroup %s: %v", name, e[name]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e SubsystemErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// 检查Set和Apply返回的错误，必须生效的子系统失败时返回错误，其他子系统只记录警告：
+// devices子系统总是必须生效，否则容器可以访问宿主机的所有设备；其他子系统在指定了对应的限制时必须生效
+func CheckRequired(res *subsystems.ResourceConfig, errs ...error) error {
+	required := map[string]bool{
+		"devices": true,
+		"memory":  res.MemoryLimit != "",
+		"cpu":     res.CpuShare != "",
+		"cpuset":  res.CpuSet != "",
+	}
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		subsystemErrs, ok := err.(SubsystemErrors)
+		if !ok {
+			return err
+		}
+		for name, subsystemErr := range subsystemErrs {
+			if required[name] {
+				return fmt.Errorf("cgroup %s error %v", name, subsystemErr)
+			}
+			log.Warnf("cgroup %s error %v", name, subsystemErr)
+		}
 	}
 	return nil
 }
 
 func (c *CgroupManager) Remove() error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
+		// 容器启动失败时cgroup可能还没有创建，cgroup v2中多个子系统是同一个目录，删过一次就不存在了
+		if _, err := subsystems.GetCgroupPath(subSysIns.Name(), c.Path, false); err != nil {
+			continue
+		}
 		if err := subSysIns.Remove(c.Path); err != nil {
 			log.Warnf("remove cgroup %s fail %v", c.Path, err)
 		}
 	}
 	return nil
 }
+
+// 删除各个子系统中已经没有进程的cgroup目录，包括其中每个容器的cgroup，返回删除的目录，dryRun时只返回不删除
+func (c *CgroupManager) Prune(dryRun bool) ([]string, error) {
+	var pruned []string
+	seen := map[string]bool{}
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		subsysCgroupPath, err := subsystems.GetCgroupPath(subSysIns.Name(), c.Path, false)
+		// cgroup v2中多个子系统是同一个目录
+		if err != nil || seen[subsysCgroupPath] {
+			continue
+		}
+		seen[subsysCgroupPath] = true
+		removed, err := pruneCgroup(subsysCgroupPath, dryRun)
+		pruned = append(pruned, removed...)
+		if err != nil {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// 先删除下一级中没有进程的cgroup，下一级都删除了并且自己也没有进程时再删除自己
+func pruneCgroup(cgroupPath string, dryRun bool) ([]string, error) {
+	entries, err := ioutil.ReadDir(cgroupPath)
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	empty := true
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		child := path.Join(cgroupPath, entry.Name())
+		removed, err := pruneCgroup(child, dryRun)
+		pruned = append(pruned, removed...)
+		if err != nil {
+			return pruned, err
+		}
+		if len(removed) == 0 || removed[len(removed)-1] != child {
+			empty = false
+		}
+	}
+	procs, err := ioutil.ReadFile(path.Join(cgroupPath, "cgroup.procs"))
+	if err != nil {
+		return pruned, err
+	}
+	if !empty || len(strings.TrimSpace(string(procs))) > 0 {
+		return pruned, nil
+	}
+	// cgroup目录中的文件不能删除，只能rmdir
+	if !dryRun {
+		if err := os.Remove(cgroupPath); err != nil {
+			return pruned, fmt.Errorf("remove cgroup %s error %v", cgroupPath, err)
+		}
+	}
+	return append(pruned, cgroupPath), nil
+}
+
+// 把进程加入另一个进程所在的cgroup，exec进入容器的进程和容器的init进程受到同样的资源限制
+// 按/proc/<pid>/cgroup逐个层级加入，没有设置资源限制的子系统里容器也可能还在根cgroup中
+func JoinProcessCgroups(targetPid int, pid int) error {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", targetPid))
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		// 每行的格式是 层级id:子系统列表:cgroup路径，cgroup v2的子系统列表为空
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		var cgroupRoot string
+		if fields[1] == "" {
+			cgroupRoot = subsystems.FindCgroup2Mountpoint()
+		} else {
+			cgroupRoot = subsystems.FindCgroupMountpoint(strings.Split(fields[1], ",")[0])
+		}
+		// 宿主机上没有挂载的层级不影响资源限制
+		if cgroupRoot == "" {
+			continue
+		}
+		procs := path.Join(cgroupRoot, fields[2], "cgroup.procs")
+		if err := ioutil.WriteFile(procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("join cgroup %s error %v", procs, err)
+		}
+	}
+	return nil
+}
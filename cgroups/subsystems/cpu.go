@@ -33,7 +33,9 @@ func (s *CpuSubSystem) Remove(cgroupPath string) error {
 
 func (s *CpuSubSystem)Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"),  []byte(strconv.Itoa(pid)), 0644); err != nil {
+		// 写cgroup.procs把进程的所有线程都移进来，写tasks只移动一个线程，
+		// init进程在其他线程上exec时容器就不受cpu限制了
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"),  []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
@@ -6,6 +6,7 @@ import(
 	"path"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type CpusetSubSystem struct {
@@ -14,6 +15,12 @@ type CpusetSubSystem struct {
 
 func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		// cgroup v1新建的cpuset中cpus和mems都是空的，这时进程加不进来，先继承上一级的设置
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			if err := inheritCpuset(subsysCgroupPath, file); err != nil {
+				return err
+			}
+		}
 		if res.CpuSet != "" {
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpuset fail %v", err)
@@ -25,6 +32,26 @@ func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	}
 }
 
+// 上一级也是新建的空cpuset时先让上一级继承，一直到已经设置了的那一级
+// cgroup v2中为空表示使用上一级的设置，上一级也为空时同样不需要处理
+func inheritCpuset(subsysCgroupPath string, file string) error {
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, file))
+	if err != nil || strings.TrimSpace(string(content)) != "" {
+		return nil
+	}
+	if err := inheritCpuset(path.Dir(subsysCgroupPath), file); err != nil {
+		return err
+	}
+	parent, err := ioutil.ReadFile(path.Join(path.Dir(subsysCgroupPath), file))
+	if err != nil || strings.TrimSpace(string(parent)) == "" {
+		return nil
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), parent, 0644); err != nil {
+		return fmt.Errorf("set cgroup %s fail %v", file, err)
+	}
+	return nil
+}
+
 func (s *CpusetSubSystem) Remove(cgroupPath string) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 		return os.RemoveAll(subsysCgroupPath)
@@ -36,7 +63,8 @@ func (s *CpusetSubSystem) Remove(cgroupPath string) error {
 
 func (s *CpusetSubSystem)Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"),  []byte(strconv.Itoa(pid)), 0644); err != nil {
+		// 和cpu子系统一样写cgroup.procs，整个进程而不只是一个线程加入cgroup
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"),  []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
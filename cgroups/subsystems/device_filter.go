@@ -0,0 +1,195 @@
+package subsystems
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// 一条设备规则，Major、Minor为-1时表示任意设备号，Type为'a'时表示任意类型
+type deviceRule struct {
+	Type   byte
+	Major  int64
+	Minor  int64
+	Access uint32
+}
+
+// 解析devices.allow格式的规则，如 c 1:3 rwm、c 136:* rw、a
+func parseDeviceRule(rule string) (*deviceRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 1 && fields[0] == "a" {
+		return &deviceRule{Type: 'a', Major: -1, Minor: -1, Access: unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE | unix.BPF_DEVCG_ACC_MKNOD}, nil
+	}
+	if len(fields) != 3 || len(fields[0]) != 1 || !strings.Contains("abc", fields[0]) {
+		return nil, fmt.Errorf("invalid device rule %q", rule)
+	}
+	r := &deviceRule{Type: fields[0][0]}
+	numbers := strings.Split(fields[1], ":")
+	if len(numbers) != 2 {
+		return nil, fmt.Errorf("invalid device rule %q", rule)
+	}
+	for i, target := range []*int64{&r.Major, &r.Minor} {
+		if numbers[i] == "*" {
+			*target = -1
+			continue
+		}
+		n, err := strconv.ParseUint(numbers[i], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device rule %q", rule)
+		}
+		*target = int64(n)
+	}
+	for _, c := range fields[2] {
+		switch c {
+		case 'r':
+			r.Access |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			r.Access |= unix.BPF_DEVCG_ACC_WRITE
+		case 'm':
+			r.Access |= unix.BPF_DEVCG_ACC_MKNOD
+		default:
+			return nil, fmt.Errorf("invalid device rule %q", rule)
+		}
+	}
+	return r, nil
+}
+
+// eBPF指令的编码
+const (
+	bpfLdxMemW  = unix.BPF_LDX | unix.BPF_MEM | unix.BPF_W
+	bpfAluAndK  = unix.BPF_ALU | unix.BPF_AND | unix.BPF_K
+	bpfAluRshK  = unix.BPF_ALU | unix.BPF_RSH | unix.BPF_K
+	bpfMov64X   = unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X
+	bpfMov64K   = unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K
+	bpfJmpJneK  = unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K
+	bpfJmpExit  = unix.BPF_JMP | unix.BPF_EXIT
+	bpfInsnSize = 8
+)
+
+type bpfInsn struct {
+	Code uint8
+	Regs uint8 // 低4位是dst，高4位是src
+	Off  int16
+	Imm  int32
+}
+
+func insn(code uint8, dst uint8, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{Code: code, Regs: dst | src<<4, Off: off, Imm: imm}
+}
+
+// 把规则编译成eBPF程序，程序返回1表示允许访问，所有规则都不匹配时返回0
+// 程序的参数r1指向struct bpf_cgroup_dev_ctx { u32 access_type; u32 major; u32 minor; }，
+// access_type的低16位是设备类型，高16位是访问方式
+func deviceFilter(rules []string) ([]bpfInsn, error) {
+	prog := []bpfInsn{
+		insn(bpfLdxMemW, 2, 1, 0, 0), // r2 = 设备类型
+		insn(bpfAluAndK, 2, 0, 0, 0xffff),
+		insn(bpfLdxMemW, 3, 1, 0, 0), // r3 = 访问方式
+		insn(bpfAluRshK, 3, 0, 0, 16),
+		insn(bpfLdxMemW, 4, 1, 4, 0), // r4 = major
+		insn(bpfLdxMemW, 5, 1, 8, 0), // r5 = minor
+	}
+	for _, rule := range rules {
+		r, err := parseDeviceRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		// 每条规则是一段指令，任何一个条件不满足就跳到下一条规则
+		var block []bpfInsn
+		switch r.Type {
+		case 'c':
+			block = append(block, insn(bpfJmpJneK, 2, 0, 0, unix.BPF_DEVCG_DEV_CHAR))
+		case 'b':
+			block = append(block, insn(bpfJmpJneK, 2, 0, 0, unix.BPF_DEVCG_DEV_BLOCK))
+		}
+		// 请求的访问方式必须是规则允许的子集
+		block = append(block,
+			insn(bpfMov64X, 6, 3, 0, 0),
+			insn(bpfAluAndK, 6, 0, 0, int32(^r.Access)),
+			insn(bpfJmpJneK, 6, 0, 0, 0),
+		)
+		if r.Major != -1 {
+			block = append(block, insn(bpfJmpJneK, 4, 0, 0, int32(r.Major)))
+		}
+		if r.Minor != -1 {
+			block = append(block, insn(bpfJmpJneK, 5, 0, 0, int32(r.Minor)))
+		}
+		block = append(block, insn(bpfMov64K, 0, 0, 0, 1), insn(bpfJmpExit, 0, 0, 0, 0))
+		for i := range block {
+			if block[i].Code == bpfJmpJneK {
+				block[i].Off = int16(len(block) - i - 1)
+			}
+		}
+		prog = append(prog, block...)
+	}
+	return append(prog, insn(bpfMov64K, 0, 0, 0, 0), insn(bpfJmpExit, 0, 0, 0, 0)), nil
+}
+
+// union bpf_attr中BPF_PROG_LOAD用到的部分
+type bpfProgLoadAttr struct {
+	ProgType    uint32
+	InsnCnt     uint32
+	Insns       uint64
+	License     uint64
+	LogLevel    uint32
+	LogSize     uint32
+	LogBuf      uint64
+	KernVersion uint32
+	ProgFlags   uint32
+}
+
+// union bpf_attr中BPF_PROG_ATTACH用到的部分
+type bpfProgAttachAttr struct {
+	TargetFd    uint32
+	AttachBpfFd uint32
+	AttachType  uint32
+	AttachFlags uint32
+}
+
+// 加载程序并挂到cgroup上，不带BPF_F_ALLOW_MULTI时会替换掉之前挂上的程序
+// 每个容器挂在自己的cgroup上，替换的只是这个容器之前的程序
+func attachDeviceFilter(prog []bpfInsn, cgroupFd int) error {
+	code := make([]byte, len(prog)*bpfInsnSize)
+	for i, in := range prog {
+		b := code[i*bpfInsnSize:]
+		b[0] = in.Code
+		b[1] = in.Regs
+		binary.LittleEndian.PutUint16(b[2:], uint16(in.Off))
+		binary.LittleEndian.PutUint32(b[4:], uint32(in.Imm))
+	}
+	license := []byte("GPL\x00")
+	loadAttr := bpfProgLoadAttr{
+		ProgType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		InsnCnt:  uint32(len(prog)),
+		Insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		License:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	progFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&loadAttr)), unsafe.Sizeof(loadAttr))
+	if errno != 0 {
+		// 加载失败时带上verifier的日志再加载一次，日志中有被拒绝的原因
+		logBuf := make([]byte, 64*1024)
+		loadAttr.LogLevel = 1
+		loadAttr.LogSize = uint32(len(logBuf))
+		loadAttr.LogBuf = uint64(uintptr(unsafe.Pointer(&logBuf[0])))
+		unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&loadAttr)), unsafe.Sizeof(loadAttr))
+		return fmt.Errorf("load bpf program error %v: %s", errno, strings.TrimRight(string(logBuf), "\x00"))
+	}
+	// 内核通过loadAttr中的地址读取指令，系统调用返回前不能被回收
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(license)
+	defer unix.Close(int(progFd))
+	attachAttr := bpfProgAttachAttr{
+		TargetFd:    uint32(cgroupFd),
+		AttachBpfFd: uint32(progFd),
+		AttachType:  unix.BPF_CGROUP_DEVICE,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attachAttr)), unsafe.Sizeof(attachAttr)); errno != 0 {
+		return fmt.Errorf("attach bpf program error %v", errno)
+	}
+	return nil
+}
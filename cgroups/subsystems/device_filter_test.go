@@ -0,0 +1,41 @@
+package subsystems
+
+import "testing"
+
+func TestParseDeviceRule(t *testing.T) {
+	r, err := parseDeviceRule("c 136:* rw")
+	if err != nil || r.Type != 'c' || r.Major != 136 || r.Minor != -1 || r.Access != 6 {
+		t.Errorf("unexpected rule %+v %v", r, err)
+	}
+	if r, err := parseDeviceRule("a"); err != nil || r.Type != 'a' || r.Access != 7 {
+		t.Errorf("unexpected rule %+v %v", r, err)
+	}
+	for _, rule := range []string{"", "x 1:3 rwm", "c 1 rwm", "c 1:3 rwx", "c a:3 r"} {
+		if _, err := parseDeviceRule(rule); err == nil {
+			t.Errorf("expect error for %q", rule)
+		}
+	}
+}
+
+func TestDeviceFilter(t *testing.T) {
+	prog, err := deviceFilter([]string{"c 1:3 rwm", "b *:* m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 6条取参数的指令，两条规则分别是8条和6条，最后是默认拒绝的2条
+	if len(prog) != 6+8+6+2 {
+		t.Fatalf("unexpected program length %d", len(prog))
+	}
+	// 每个条件跳转都跳到本条规则之后
+	for i, in := range prog[6:14] {
+		if in.Code == bpfJmpJneK && int(in.Off) != 8-i-1 {
+			t.Errorf("instruction %d jumps to %d", i, in.Off)
+		}
+	}
+	if last := prog[len(prog)-2]; last.Code != bpfMov64K || last.Imm != 0 {
+		t.Errorf("expect default deny, got %+v", last)
+	}
+	if _, err := deviceFilter([]string{"bad"}); err == nil {
+		t.Errorf("expect error for invalid rule")
+	}
+}
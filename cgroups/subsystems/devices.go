@@ -0,0 +1,96 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// 容器默认可以访问的设备，规则格式与devices.allow相同: 类型 主设备号:次设备号 权限
+var DefaultDeviceRules = []string{
+	"c 1:3 rwm",   // null
+	"c 1:5 rwm",   // zero
+	"c 1:7 rwm",   // full
+	"c 1:8 rwm",   // random
+	"c 1:9 rwm",   // urandom
+	"c 5:0 rwm",   // tty
+	"c 5:2 rwm",   // ptmx
+	"c 136:* rwm", // pts
+}
+
+// devices子系统，先禁止所有设备，再放开默认设备和--device指定的设备
+// 规则只写在容器自己的cgroup中，不会影响其他正在运行的容器
+// cgroup v1使用devices.deny/devices.allow，只有cgroup v2时通过eBPF程序实现同样的规则
+type DevicesSubSystem struct {
+}
+
+func (s *DevicesSubSystem) Name() string {
+	return "devices"
+}
+
+func (s *DevicesSubSystem) rules(res *ResourceConfig) []string {
+	return append(append([]string{}, DefaultDeviceRules...), res.Devices...)
+}
+
+func (s *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if FindCgroupMountpoint(s.Name()) == "" {
+		return setDevicesV2(cgroupPath, s.rules(res))
+	}
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("set cgroup devices deny fail %v", err)
+	}
+	for _, rule := range s.rules(res) {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.allow"), []byte(rule), 0644); err != nil {
+			return fmt.Errorf("set cgroup devices allow %s fail %v", rule, err)
+		}
+	}
+	return nil
+}
+
+func (s *DevicesSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *DevicesSubSystem) Apply(cgroupPath string, pid int) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+	// 写cgroup.procs让init进程的所有线程都加入cgroup，写tasks只会移动主线程，
+	// 而init进程可能在其他线程上exec，exec之后的容器进程就不受cgroup限制了
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// cgroup v2没有devices控制器，把规则编译成BPF_PROG_TYPE_CGROUP_DEVICE程序挂到cgroup目录上
+func setDevicesV2(cgroupPath string, rules []string) error {
+	subsysCgroupPath, err := GetCgroupPath("devices", cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	insns, err := deviceFilter(rules)
+	if err != nil {
+		return err
+	}
+	dir, err := os.Open(subsysCgroupPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := attachDeviceFilter(insns, int(dir.Fd())); err != nil {
+		return fmt.Errorf("attach device filter to %s fail %v", subsysCgroupPath, err)
+	}
+	return nil
+}
@@ -42,7 +42,9 @@ func (s *MemorySubSystem) Remove(cgroupPath string) error {
 func (s *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		// 写cgroup.procs而不是tasks，init进程的所有线程都加入cgroup，
+		// 否则在其他线程上exec出的用户程序不受内存限制
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf(" set cgroup proc fail %v", err)
 		}
 		return nil
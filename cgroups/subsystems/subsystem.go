@@ -6,6 +6,8 @@ type ResourceConfig struct {
 	CpuShare string
 	// CPU核心数
 	CpuSet string
+	// 默认设备之外允许访问的设备，格式与devices.allow相同
+	Devices []string
 }
 
 type Subsystem interface {
@@ -20,5 +22,6 @@ var (
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
 		&CpuSubSystem{},
+		&DevicesSubSystem{},
 	}
 )
@@ -36,12 +36,40 @@ func FindCgroupMountpoint(subsystem string) string {
 	return ""
 }
 
-// 获取目标cgroup挂载点，不存在就新建一个
+// 找到cgroup v2的挂载点，mountinfo中" - "之后的第一个字段是文件系统类型
+func FindCgroup2Mountpoint() string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		txt := scanner.Text()
+		i := strings.Index(txt, " - ")
+		if i == -1 {
+			continue
+		}
+		if fields := strings.Fields(txt[i+3:]); len(fields) > 0 && fields[0] == "cgroup2" {
+			return strings.Split(txt, " ")[4]
+		}
+	}
+	return ""
+}
+
+// 获取目标cgroup挂载点，不存在就新建一个，上一级不存在时一起新建
+// 子系统没有挂载cgroup v1时使用cgroup v2的统一层级
 func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
 	cgroupRoot := FindCgroupMountpoint(subsystem)
+	if cgroupRoot == "" {
+		cgroupRoot = FindCgroup2Mountpoint()
+	}
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("cgroup %s not mounted", subsystem)
+	}
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			if err := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
+			if err := os.MkdirAll(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
 			} else {
 				return "", fmt.Errorf("error create cgroup %v", err)
 			}
@@ -1,20 +1,73 @@
 package main
 
 import (
+	"example/mydocker/archive"
 	"example/mydocker/container"
+	"example/mydocker/paths"
 	"fmt"
-	"os/exec"
+	"io"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func CommitContainer(containerName string, imageName string) {
-	mntURL := fmt.Sprintf(container.MntUrl, containerName)
-	imageTarURL := container.RootUrl + "/" + imageName + ".tar"
-	// 此处必须要使用-C将tar目录切换到mntURL，如果直接指定mntURL，会将mntURL也带入，
-	// 导致压缩文件包含/root/overlayFS/mnt才到镜像文件所在目录
-	if a, err := exec.Command("tar", "-czf", imageTarURL, "-C", mntURL, ".").CombinedOutput(); err != nil {
-		fmt.Println(a)
-		log.Errorf("tar folder %s error %v", imageTarURL, err)
+// 把容器提交为镜像：容器记录了镜像时，在镜像的基础上叠加存储驱动得到的容器的修改；
+// 否则把合并后的rootfs(mntURL)整个打包成一层
+func CommitContainer(containerName string, imageName string) error {
+	ref, err := container.ParseReference(imageName)
+	if err != nil {
+		return err
 	}
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	config := &container.ImageConfig{}
+	var diff io.ReadCloser
+	if containerInfo.Image != "" {
+		if base, err := container.ReadImageConfig(containerInfo.Image); err == nil {
+			config = base
+			driver, err := containerStorageDriver(containerInfo)
+			if err != nil {
+				return err
+			}
+			if diff, err = driver.Diff(containerName, containerInfo.Image); err != nil {
+				return err
+			}
+		} else {
+			log.Warnf("read image %s of container %s error %v, commit the whole rootfs", containerInfo.Image, containerName, err)
+		}
+	}
+	if diff == nil {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(archive.Tar(paths.MntUrl(containerName), pw, nil))
+		}()
+		diff = pr
+	}
+
+	layer, err := container.CreateLayerFromTar(archive.ProgressReader(diff, archive.LogProgress("commit "+containerName)))
+	diff.Close()
+	if err != nil {
+		return fmt.Errorf("commit container %s error %v", containerName, err)
+	}
+	config.Layers = append(config.Layers, layer)
+	id, err := container.CreateImage(config)
+	if err != nil {
+		return err
+	}
+	if err := container.TagImage(id, ref); err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
+
+// 创建容器时使用的存储驱动
+func containerStorageDriver(containerInfo *container.ContainerInfo) (container.StorageDriver, error) {
+	driverName := containerInfo.StorageDriver
+	if driverName == "" {
+		driverName = paths.DefaultStorageDriver
+	}
+	return container.GetStorageDriver(driverName)
 }
@@ -0,0 +1,183 @@
+package container
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"example/mydocker/paths"
+	"example/mydocker/term"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// supervisor的attach socket，在容器目录下
+const AttachSocketName = "attach.sock"
+
+// attach socket上双向传递的帧，帧头8字节：类型、3字节0、4字节大端的长度，格式和docker的stdcopy相同
+const (
+	frameStdin byte = iota
+	frameStdout
+	frameStderr
+	// 客户端终端窗口大小变化，内容是json格式的term.Winsize
+	frameResize
+	// 客户端的标准输入结束，关闭容器的标准输入
+	frameCloseStdin
+)
+
+const frameHeaderSize = 8
+
+// 分离的按键序列Ctrl-P Ctrl-Q
+const (
+	ctrlP = 0x10
+	ctrlQ = 0x11
+)
+
+func writeFrame(w io.Writer, kind byte, data []byte) error {
+	_, err := w.Write(encodeFrame(kind, data))
+	return err
+}
+
+func encodeFrame(kind byte, data []byte) []byte {
+	frame := make([]byte, frameHeaderSize+len(data))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[4:frameHeaderSize], uint32(len(data)))
+	copy(frame[frameHeaderSize:], data)
+	return frame
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return header[0], data, nil
+}
+
+// 在用户的输入中查找Ctrl-P Ctrl-Q，Ctrl-P要等下一个按键才知道是否转发
+type detachKeys struct {
+	pending bool
+}
+
+// 返回需要转发给容器的内容，遇到分离序列时返回true，序列之后的输入丢弃
+func (d *detachKeys) scan(p []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(p)+1)
+	for _, b := range p {
+		if d.pending {
+			d.pending = false
+			if b == ctrlQ {
+				return out, true
+			}
+			out = append(out, ctrlP)
+		}
+		if b == ctrlP {
+			d.pending = true
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
+
+// 输入结束时返回还在等待下一个字节的Ctrl-P，它也要转发给容器
+func (d *detachKeys) flush() []byte {
+	if !d.pending {
+		return nil
+	}
+	d.pending = false
+	return []byte{ctrlP}
+}
+
+// 连接容器的supervisor，容器启动之前连上才不会错过容器最开始的输出
+func DialAttach(containerName string) (*net.UnixConn, error) {
+	socket := filepath.Join(paths.ContainerUrl(containerName), AttachSocketName)
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to container %s error %v", containerName, err)
+	}
+	return conn, nil
+}
+
+// 把用户的终端接到容器上，直到容器退出或者用户按Ctrl-P Ctrl-Q分离，分离时返回true
+// tty时用户的终端切换到raw模式，窗口大小的变化同步给容器；stdin为false时只接收容器的输出
+func Attach(conn *net.UnixConn, tty bool, stdin bool) (bool, error) {
+	defer conn.Close()
+	var mu sync.Mutex
+	send := func(kind byte, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return writeFrame(conn, kind, data)
+	}
+	if tty {
+		restore := forwardTerminal(func(ws *term.Winsize) {
+			data, _ := json.Marshal(ws)
+			send(frameResize, data)
+		})
+		defer restore()
+	}
+
+	detached := make(chan struct{})
+	if stdin {
+		go func() {
+			keys := &detachKeys{}
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := os.Stdin.Read(buf)
+				if n > 0 {
+					data, detach := buf[:n], false
+					if tty {
+						data, detach = keys.scan(data)
+					}
+					if len(data) > 0 {
+						if err := send(frameStdin, data); err != nil {
+							return
+						}
+					}
+					if detach {
+						close(detached)
+						// 关闭连接让下面读取容器输出的循环返回
+						conn.Close()
+						return
+					}
+				}
+				if err != nil {
+					if pending := keys.flush(); len(pending) > 0 {
+						send(frameStdin, pending)
+					}
+					// 没有终端时标准输入结束就关闭容器的标准输入，终端的输入由容器中的终端处理
+					if !tty {
+						send(frameCloseStdin, nil)
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		kind, data, err := readFrame(conn)
+		if err != nil {
+			select {
+			case <-detached:
+				return true, nil
+			default:
+			}
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		switch kind {
+		case frameStdout:
+			os.Stdout.Write(data)
+		case frameStderr:
+			os.Stderr.Write(data)
+		}
+	}
+}
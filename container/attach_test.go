@@ -0,0 +1,70 @@
+package container
+
+import (
+	"bytes"
+	"example/mydocker/logger"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(&buf, frameStdout, []byte("hello"))
+	writeFrame(&buf, frameCloseStdin, nil)
+	if kind, data, err := readFrame(&buf); err != nil || kind != frameStdout || string(data) != "hello" {
+		t.Errorf("unexpected frame %d %q %v", kind, data, err)
+	}
+	if kind, data, err := readFrame(&buf); err != nil || kind != frameCloseStdin || len(data) != 0 {
+		t.Errorf("unexpected frame %d %q %v", kind, data, err)
+	}
+	if _, _, err := readFrame(&buf); err != io.EOF {
+		t.Errorf("expect EOF, got %v", err)
+	}
+}
+
+func TestDetachKeys(t *testing.T) {
+	keys := &detachKeys{}
+	if out, detach := keys.scan([]byte("a\x10b")); detach || string(out) != "a\x10b" {
+		t.Errorf("unexpected %q %v", out, detach)
+	}
+	// Ctrl-P和Ctrl-Q分在两次输入中
+	if out, detach := keys.scan([]byte("c\x10")); detach || string(out) != "c" {
+		t.Errorf("unexpected %q %v", out, detach)
+	}
+	if out, detach := keys.scan([]byte("\x11d")); !detach || len(out) != 0 {
+		t.Errorf("unexpected %q %v", out, detach)
+	}
+	keys = &detachKeys{}
+	if out, detach := keys.scan([]byte("\x10\x10\x11")); !detach || string(out) != "\x10" {
+		t.Errorf("unexpected %q %v", out, detach)
+	}
+	// 输入在Ctrl-P之后结束，Ctrl-P仍然要交给容器
+	keys = &detachKeys{}
+	if out, detach := keys.scan([]byte("e\x10")); detach || string(out) != "e" {
+		t.Errorf("unexpected %q %v", out, detach)
+	}
+	if out := keys.flush(); string(out) != "\x10" {
+		t.Errorf("expect pending Ctrl-P, got %q", out)
+	}
+	if out := keys.flush(); len(out) != 0 {
+		t.Errorf("expect nothing pending, got %q", out)
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var lines []string
+	w := &logWriter{stream: logger.Stdout, log: func(msg *logger.Message) error {
+		lines = append(lines, string(msg.Line))
+		return nil
+	}}
+	w.Write([]byte("a\nb"))
+	w.Write([]byte("c\n\nd"))
+	long := strings.Repeat("x", maxLogLineSize+10)
+	w.Write([]byte(long + "\n"))
+	w.flush()
+	expect := []string{"a\n", "bc\n", "\n", "d" + long[:maxLogLineSize-1], long[maxLogLineSize-1:] + "\n"}
+	if strings.Join(lines, "|") != strings.Join(expect, "|") {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
@@ -0,0 +1,62 @@
+package container
+
+import (
+	"example/mydocker/term"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 把用户的终端交给容器使用，attach和exec -ti共用：标准输入是终端时切换到raw模式，
+// 按键原样交给容器，开始时和窗口大小变化时调用resize；返回的函数恢复用户的终端
+func forwardTerminal(resize func(*term.Winsize)) func() {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return func() {}
+	}
+	state, err := term.MakeRaw(os.Stdin.Fd())
+	if err != nil {
+		log.Warnf("set terminal raw mode error %v", err)
+	}
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			if ws, err := term.GetWinsize(os.Stdin.Fd()); err == nil {
+				resize(ws)
+			}
+		}
+	}()
+	if ws, err := term.GetWinsize(os.Stdin.Fd()); err == nil {
+		resize(ws)
+	}
+	return func() {
+		signal.Stop(winch)
+		close(winch)
+		if state != nil {
+			if err := term.Restore(os.Stdin.Fd(), state); err != nil {
+				log.Errorf("restore terminal error %v", err)
+			}
+		}
+	}
+}
+
+// 在用户的终端和伪终端的master之间转发输入输出，返回的函数等伪终端的输出转发完后恢复用户的终端
+// 伪终端的slave都关闭后读master返回EIO，转发随之结束
+func RelayConsole(master *os.File) func() {
+	restore := forwardTerminal(func(ws *term.Winsize) {
+		term.SetWinsize(master.Fd(), ws)
+	})
+	go io.Copy(master, os.Stdin)
+	done := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, master)
+		close(done)
+	}()
+	return func() {
+		<-done
+		restore()
+	}
+}
@@ -1,46 +1,60 @@
 package container
 
 import (
+	"example/mydocker/logger"
+	"example/mydocker/paths"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
 )
 
 type ContainerInfo struct {
-	Pid        string `json:"pid"`
-	Id         string `json:"id"`
-	Name       string `json:"name"`
-	Command    string `json:"command"`
-	CreateTime string `json:"createTime"`
-	Status     string `json:"status"`
-	Volume     string `json:"volume"`
+	Pid         string   `json:"pid"`
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Command     string   `json:"command"`
+	CreateTime  string   `json:"createTime"`
+	Status      string   `json:"status"`
+	Mounts      []Mount  `json:"mounts,omitempty"`
+	Image       string   `json:"image"`
 	PortMapping []string `json:"portmapping"`
+	Network     string   `json:"network,omitempty"`
+	IPAddress   string   `json:"ip,omitempty"`
+	// 创建rootfs的存储驱动，为空的是支持存储驱动之前用overlay创建的容器
+	StorageDriver string `json:"storageDriver,omitempty"`
+	// 日志驱动和选项，为空的是支持日志驱动之前创建的容器，使用json-file
+	LogConfig *logger.Config `json:"logConfig,omitempty"`
+	// 容器的标准输入输出是伪终端，attach时用户的终端要切换到raw模式
+	Tty bool `json:"tty,omitempty"`
+	// 容器的标准输入是打开的，attach时可以写入
+	OpenStdin bool `json:"openStdin,omitempty"`
+	// 容器的rootfs以只读方式挂载，cp不能往其中写入
+	ReadOnlyRoot bool `json:"readOnlyRoot,omitempty"`
 }
 
 var (
-	Running             string = "running"
-	Stop                string = "stopped"
-	Exit                string = "exited"
-	DefaultInfoLocation string = "/var/run/mydocker/container/%s/"
-	ConfigName          string = "config.json"
-	LogName             string = "container.log"
+	Running        string = "running"
+	Stop           string = "stopped"
+	Exit           string = "exited"
+	ConfigName     string = "config.json"
+	LogName        string = "container-json.log"
+	DefaultPathEnv string = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
 )
 
-var (
-	RootUrl       string = "../overlayFS"
-	MntUrl        string = "../overlayFS/mnt/%s"
-	WriteLayerUrl string = "../overlayFS/writeLayer/%s"
-	WorkLayerUrl  string = "../overlayFS/work/%s"
-)
-
-func NewParentProcess(tty bool, volume string, containerName string, imageName string, environment []string) (*exec.Cmd, *os.File) {
+// 创建init进程和容器的workspace，返回的写端管道用来给init进程发送启动参数
+// 返回错误时已经清理掉创建的管道和workspace
+func NewParentProcess(tty bool, mounts []Mount, containerName string, imageID string, environment []string, storageOpts *StorageOptions) (*exec.Cmd, *os.File, error) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
-		log.Errorf("New pipe error %v", err)
-		return nil, nil
+		return nil, nil, fmt.Errorf("new pipe error %v", err)
+	}
+	closePipes := func() {
+		readPipe.Close()
+		writePipe.Close()
 	}
 	log.Debug("NewParentProcess")
 	cmd := exec.Command("/proc/self/exe", "init")
@@ -48,38 +62,42 @@ func NewParentProcess(tty bool, volume string, containerName string, imageName s
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
 			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
-	if tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
-		log.Debug("create log.json")
-		logDir := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err := os.MkdirAll(logDir, 0622); err != nil {
-			log.Errorf("mkdir logDir:%s error %v", logDir, err)
-			return nil, nil
-		}
-		fileName := logDir + "/" + LogName
-		file, err := os.Create(fileName)
-		if err != nil {
-			log.Errorf("create log file:%s error %v", fileName, err)
-			return nil, nil
-		}
-		cmd.Stdout = file
-	}
+	// 容器的标准输入输出由supervisor持有，见StartSupervisor
 	cmd.ExtraFiles = []*os.File{readPipe}
+	// 指定了环境变量时就不会继承宿主机的环境变量，没有PATH的话补上默认值，否则容器内找不到命令
+	if len(environment) > 0 && !hasEnv(environment, "PATH") {
+		cmd.Env = append(cmd.Env, DefaultPathEnv)
+	}
 	cmd.Env = append(cmd.Env, environment...)
-	NewWorkSpace(volume, containerName, imageName)
+	if err := PrepareMounts(mounts); err != nil {
+		closePipes()
+		return nil, nil, fmt.Errorf("prepare mounts error %v", err)
+	}
+	if err := NewWorkSpace(containerName, imageID, storageOpts); err != nil {
+		closePipes()
+		return nil, nil, fmt.Errorf("create workspace error %v", err)
+	}
 	// setUpMount()的GetWd获取
-	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
-	// cmd.Dir = "./busybox"
-	return cmd, writePipe
+	cmd.Dir = paths.MntUrl(containerName)
+	return cmd, writePipe, nil
 }
 
+// 父进程和init进程之间的管道，用socketpair实现，是双向的：
+// 父进程写入InitConfig后关闭写端，init进程启动失败时把错误写回给父进程；
+// init进程exec成功后管道自动关闭，父进程读到EOF
 func NewPipe() (*os.File, *os.File, error) {
-	read, write, err := os.Pipe()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return nil, nil, err
 	}
-	return read, write, nil
+	return os.NewFile(uintptr(fds[0]), "init-pipe"), os.NewFile(uintptr(fds[1]), "parent-pipe"), nil
+}
+
+func hasEnv(environment []string, key string) bool {
+	for _, env := range environment {
+		if strings.HasPrefix(env, key+"=") {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,221 @@
+package container
+
+import (
+	"example/mydocker/term"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 容器内的设备节点，Path是容器内的路径，HostPath是宿主机上对应的设备，
+// Permissions是cgroup中的访问权限，由r(读)、w(写)、m(mknod)组成
+type Device struct {
+	Type        string      `json:"type"`
+	Path        string      `json:"path"`
+	HostPath    string      `json:"hostPath,omitempty"`
+	Major       int64       `json:"major"`
+	Minor       int64       `json:"minor"`
+	Permissions string      `json:"permissions"`
+	FileMode    os.FileMode `json:"fileMode"`
+	Uid         uint32      `json:"uid"`
+	Gid         uint32      `json:"gid"`
+}
+
+// 每个容器都有的设备
+var DefaultDevices = []Device{
+	{Type: "c", Path: "/dev/null", Major: 1, Minor: 3, Permissions: "rwm", FileMode: 0666},
+	{Type: "c", Path: "/dev/zero", Major: 1, Minor: 5, Permissions: "rwm", FileMode: 0666},
+	{Type: "c", Path: "/dev/full", Major: 1, Minor: 7, Permissions: "rwm", FileMode: 0666},
+	{Type: "c", Path: "/dev/random", Major: 1, Minor: 8, Permissions: "rwm", FileMode: 0666},
+	{Type: "c", Path: "/dev/urandom", Major: 1, Minor: 9, Permissions: "rwm", FileMode: 0666},
+	{Type: "c", Path: "/dev/tty", Major: 5, Minor: 0, Permissions: "rwm", FileMode: 0666},
+}
+
+// /dev/shm的默认大小
+const DefaultShmSize = 64 << 20
+
+// 解析 --device 参数，格式为 hostPath[:containerPath][:permissions]，如 /dev/fuse、/dev/sdb:/dev/xvdb:r
+func ParseDevice(spec string) (*Device, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid device %q, expect hostPath[:containerPath][:permissions]", spec)
+	}
+	hostPath, path, permissions := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 2:
+		// 第二段是权限还是容器内的路径
+		if validPermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			path = parts[1]
+		}
+	case 3:
+		path, permissions = parts[1], parts[2]
+	}
+	if !filepath.IsAbs(hostPath) || !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("invalid device %q, device path must be absolute", spec)
+	}
+	if !validPermissions(permissions) {
+		return nil, fmt.Errorf("invalid device %q, permissions must be a combination of r, w and m", spec)
+	}
+	var stat unix.Stat_t
+	if err := unix.Stat(hostPath, &stat); err != nil {
+		return nil, fmt.Errorf("stat device %s error %v", hostPath, err)
+	}
+	d := &Device{
+		Path:        filepath.Clean(path),
+		HostPath:    filepath.Clean(hostPath),
+		Major:       int64(unix.Major(uint64(stat.Rdev))),
+		Minor:       int64(unix.Minor(uint64(stat.Rdev))),
+		Permissions: permissions,
+		FileMode:    os.FileMode(stat.Mode &^ unix.S_IFMT),
+		Uid:         stat.Uid,
+		Gid:         stat.Gid,
+	}
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		d.Type = "c"
+	case unix.S_IFBLK:
+		d.Type = "b"
+	default:
+		return nil, fmt.Errorf("%s is not a device", hostPath)
+	}
+	return d, nil
+}
+
+func validPermissions(permissions string) bool {
+	if permissions == "" || len(permissions) > 3 {
+		return false
+	}
+	for _, c := range permissions {
+		if !strings.ContainsRune("rwm", c) || strings.Count(permissions, string(c)) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// cgroup devices.allow格式的规则，如 c 10:229 rwm
+func (d *Device) CgroupRule() string {
+	return fmt.Sprintf("%s %d:%d %s", d.Type, d.Major, d.Minor, d.Permissions)
+}
+
+// 在容器的/dev中创建设备节点，没有mknod权限时(如在user namespace中)从宿主机bind挂载
+func createDevices(devices []Device, oldRoot string) error {
+	oldMask := syscall.Umask(0)
+	defer syscall.Umask(oldMask)
+	for i := range devices {
+		if err := createDevice(&devices[i], oldRoot); err != nil {
+			return fmt.Errorf("create device %s error %v", devices[i].Path, err)
+		}
+	}
+	return nil
+}
+
+func createDevice(d *Device, oldRoot string) error {
+	if err := os.MkdirAll(filepath.Dir(d.Path), 0755); err != nil {
+		return err
+	}
+	mode := uint32(d.FileMode.Perm())
+	if d.Type == "b" {
+		mode |= unix.S_IFBLK
+	} else {
+		mode |= unix.S_IFCHR
+	}
+	err := unix.Mknod(d.Path, mode, int(unix.Mkdev(uint32(d.Major), uint32(d.Minor))))
+	if err == nil {
+		return os.Chown(d.Path, int(d.Uid), int(d.Gid))
+	}
+	if err != unix.EPERM {
+		return err
+	}
+	hostPath := d.HostPath
+	if hostPath == "" {
+		hostPath = d.Path
+	}
+	file, err := os.OpenFile(d.Path, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return syscall.Mount(filepath.Join(oldRoot, hostPath), d.Path, "bind", syscall.MS_BIND, "")
+}
+
+// /dev下约定俗成的符号链接
+var defaultSymlinks = [][2]string{
+	{"/proc/self/fd", "/dev/fd"},
+	{"/proc/self/fd/0", "/dev/stdin"},
+	{"/proc/self/fd/1", "/dev/stdout"},
+	{"/proc/self/fd/2", "/dev/stderr"},
+	{"pts/ptmx", "/dev/ptmx"},
+}
+
+// 挂载/dev下的devpts、shm、mqueue，创建设备节点和符号链接，此时/dev已经是一个空的tmpfs
+func setUpDev(devices []Device, shmSize int64, oldRoot string) error {
+	if err := createDevices(append(append([]Device{}, DefaultDevices...), devices...), oldRoot); err != nil {
+		return err
+	}
+	for _, link := range defaultSymlinks {
+		if err := os.Symlink(link[0], link[1]); err != nil {
+			return fmt.Errorf("symlink %s error %v", link[1], err)
+		}
+	}
+	// newinstance让容器有自己的pty编号空间，/dev/ptmx指向其中的ptmx
+	if err := os.MkdirAll("/dev/pts", 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("devpts", "/dev/pts", "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620,gid=5"); err != nil {
+		return fmt.Errorf("mount devpts error %v", err)
+	}
+	// -ti时标准输入是宿主机上分配的伪终端，容器中看不到宿主机的/dev/pts，
+	// 把它bind挂载到/dev/console，tty等命令才能在/dev下找到自己的终端
+	if term.IsTerminal(os.Stdin.Fd()) {
+		if err := bindConsole(oldRoot); err != nil {
+			return err
+		}
+	}
+	if shmSize <= 0 {
+		shmSize = DefaultShmSize
+	}
+	if err := os.MkdirAll("/dev/shm", 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("shm", "/dev/shm", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, fmt.Sprintf("mode=1777,size=%d", shmSize)); err != nil {
+		return fmt.Errorf("mount /dev/shm error %v", err)
+	}
+	if err := os.MkdirAll("/dev/mqueue", 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("mqueue", "/dev/mqueue", "mqueue", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount mqueue error %v", err)
+	}
+	return nil
+}
+
+// 标准输入的fd属于父进程的mount namespace，不能直接作为bind的源，
+// 根据设备号找到旧的根目录下对应的/dev/pts/N来挂载
+func bindConsole(oldRoot string) error {
+	var stat unix.Stat_t
+	if err := unix.Fstat(int(os.Stdin.Fd()), &stat); err != nil {
+		return err
+	}
+	// UNIX98伪终端的slave主设备号是136到143
+	major, minor := unix.Major(stat.Rdev), unix.Minor(stat.Rdev)
+	if major < 136 || major > 143 {
+		return nil
+	}
+	source := filepath.Join(oldRoot, fmt.Sprintf("/dev/pts/%d", (major-136)*256+minor))
+	file, err := os.OpenFile("/dev/console", os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("create /dev/console error %v", err)
+	}
+	file.Close()
+	if err := syscall.Mount(source, "/dev/console", "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s to /dev/console error %v", source, err)
+	}
+	return nil
+}
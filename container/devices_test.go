@@ -0,0 +1,21 @@
+package container
+
+import "testing"
+
+func TestParseDevice(t *testing.T) {
+	d, err := ParseDevice("/dev/null:/dev/mynull:rw")
+	if err != nil {
+		t.Fatalf("parse device error %v", err)
+	}
+	if d.Path != "/dev/mynull" || d.HostPath != "/dev/null" || d.CgroupRule() != "c 1:3 rw" {
+		t.Errorf("unexpected device %+v", d)
+	}
+	if d, err := ParseDevice("/dev/null:r"); err != nil || d.Path != "/dev/null" || d.Permissions != "r" {
+		t.Errorf("unexpected device %+v %v", d, err)
+	}
+	for _, spec := range []string{"", "dev/null", "/dev/null:/dev/x:rwx", "/dev/null:rr", "/etc/hostname"} {
+		if _, err := ParseDevice(spec); err == nil {
+			t.Errorf("expect error for %q", spec)
+		}
+	}
+}
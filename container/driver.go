@@ -0,0 +1,50 @@
+package container
+
+import (
+	"example/mydocker/archive"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// 存储驱动负责根据镜像层创建容器的rootfs，以及在容器的读写层和镜像层格式的tar之间转换
+// 所有驱动都把rootfs挂载到MntUrl，读写的内容放在WriteLayerUrl下，
+// 所以卸载和删除workspace不需要知道创建它的驱动，见DeleteWorkSpace
+type StorageDriver interface {
+	Name() string
+	// 为容器创建读写层，storageOpts为nil时使用默认选项
+	Create(containerName string, imageID string, storageOpts *StorageOptions) error
+	// 把容器的rootfs挂载到MntUrl
+	Mount(containerName string, imageID string) error
+	Unmount(containerName string) error
+	// 删除容器的读写层，需要先Unmount
+	Remove(containerName string) error
+	// 容器相对于镜像的修改，打包成镜像层格式的tar，删除的文件用.wh.文件表示
+	Diff(containerName string, imageID string) (io.ReadCloser, error)
+	// 把镜像层格式的tar应用到容器的读写层上
+	ApplyDiff(containerName string, diff io.Reader) error
+	// 容器中新增、修改和删除的文件，按路径排序
+	Changes(containerName string, imageID string) ([]archive.Change, error)
+}
+
+var storageDrivers = map[string]StorageDriver{}
+
+func init() {
+	for _, driver := range []StorageDriver{&OverlayDriver{}, &VfsDriver{}} {
+		storageDrivers[driver.Name()] = driver
+	}
+}
+
+func GetStorageDriver(name string) (StorageDriver, error) {
+	driver, ok := storageDrivers[name]
+	if !ok {
+		var names []string
+		for n := range storageDrivers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown storage driver %s, supported drivers: %s", name, strings.Join(names, ", "))
+	}
+	return driver, nil
+}
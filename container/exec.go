@@ -0,0 +1,99 @@
+package container
+
+import (
+	"encoding/json"
+	"example/mydocker/term"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// mydocker exec通过管道发给容器中的进程的参数
+type ExecConfig struct {
+	Args []string `json:"args"`
+	// 完整的环境变量，不会再继承其他的环境变量
+	Env []string `json:"env"`
+	// 用户名或uid，可以跟:组名或gid，为空时是root
+	User       string `json:"user,omitempty"`
+	WorkingDir string `json:"workingDir,omitempty"`
+	// 标准输入输出是伪终端，在新的会话中把它设置为控制终端
+	Tty bool `json:"tty,omitempty"`
+}
+
+// exec进入容器的进程在Go代码开始执行之前已经由nsenter进入了容器的namespace，
+// 这里读取参数，切换用户和工作目录后exec用户程序，和init进程一样通过fd 3的管道返回结果
+func RunContainerExecProcess() error {
+	pipe := os.NewFile(uintptr(3), "pipe")
+	// exec成功后管道随之关闭，mydocker读到EOF就知道用户程序已经启动
+	syscall.CloseOnExec(int(pipe.Fd()))
+	err := runContainerExec(pipe)
+	pipe.Write([]byte(err.Error()))
+	return err
+}
+
+func runContainerExec(pipe *os.File) error {
+	msg, err := ioutil.ReadAll(pipe)
+	if err != nil {
+		return fmt.Errorf("read exec config error %v", err)
+	}
+	config := &ExecConfig{}
+	if err := json.Unmarshal(msg, config); err != nil {
+		return fmt.Errorf("parse exec config error %v", err)
+	}
+	if len(config.Args) == 0 {
+		return fmt.Errorf("missing exec command")
+	}
+	// nsenter的进程在容器的pid namespace之外，用户程序需要自己的会话和进程组，
+	// 否则shell等程序看不到终端原来的前台进程组
+	if config.Tty {
+		if _, err := syscall.Setsid(); err != nil {
+			return fmt.Errorf("setsid error %v", err)
+		}
+		if err := term.SetControllingTerminal(os.Stdin.Fd()); err != nil {
+			return fmt.Errorf("set controlling terminal error %v", err)
+		}
+	}
+	// 查找命令时使用容器中的PATH
+	os.Clearenv()
+	for _, env := range config.Env {
+		if key, value, ok := strings.Cut(env, "="); ok {
+			os.Setenv(key, value)
+		}
+	}
+	if config.User != "" {
+		user, err := LookupUser(config.User)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Setgroups(user.Groups); err != nil {
+			return fmt.Errorf("setgroups error %v", err)
+		}
+		if err := syscall.Setgid(user.Gid); err != nil {
+			return fmt.Errorf("setgid %d error %v", user.Gid, err)
+		}
+		if err := syscall.Setuid(user.Uid); err != nil {
+			return fmt.Errorf("setuid %d error %v", user.Uid, err)
+		}
+		if _, ok := os.LookupEnv("HOME"); !ok {
+			os.Setenv("HOME", user.Home)
+		}
+	}
+	workingDir := config.WorkingDir
+	if workingDir == "" {
+		workingDir = "/"
+	}
+	if err := syscall.Chdir(workingDir); err != nil {
+		return fmt.Errorf("chdir %s error %v", workingDir, err)
+	}
+	path, err := exec.LookPath(config.Args[0])
+	if err != nil {
+		return fmt.Errorf("exec look path error %v", err)
+	}
+	if err := syscall.Exec(path, config.Args, os.Environ()); err != nil {
+		return fmt.Errorf("exec %s error %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package container
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"example/mydocker/paths"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ImageConfig struct {
+	Cmd        []string          `json:"cmd"`
+	Env        []string          `json:"env"`
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	WorkingDir string            `json:"workingDir,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	// 镜像层的id，从下往上排列
+	Layers  []string `json:"layers,omitempty"`
+	Created string   `json:"created"`
+}
+
+// 镜像id是镜像配置json的sha256，不包括创建时间，同样的内容总是得到同样的id
+func ImageID(config *ImageConfig) (string, error) {
+	content := *config
+	content.Created = ""
+	jsonBytes, err := json.Marshal(&content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(jsonBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// 保存一个新的镜像，同样的内容只保存一份，已经存在的镜像保留原来的创建时间
+func CreateImage(config *ImageConfig) (string, error) {
+	id, _, err := SaveImage(config)
+	return id, err
+}
+
+// 和CreateImage相同，同时返回镜像是不是这次新保存的
+func SaveImage(config *ImageConfig) (string, bool, error) {
+	if len(config.Layers) == 0 {
+		return "", false, fmt.Errorf("image has no layer")
+	}
+	id, err := ImageID(config)
+	if err != nil {
+		return "", false, err
+	}
+	imageURL := paths.ImageUrl(id)
+	if _, err := os.Stat(imageURL); err == nil {
+		return id, false, nil
+	}
+	content := *config
+	content.Created = time.Now().Format("2006-01-02 15:04:05")
+	jsonBytes, err := json.Marshal(&content)
+	if err != nil {
+		return "", false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(imageURL), 0755); err != nil {
+		return "", false, err
+	}
+	if err := ioutil.WriteFile(imageURL, jsonBytes, 0644); err != nil {
+		return "", false, err
+	}
+	return id, true, nil
+}
+
+func ReadImageConfig(id string) (*ImageConfig, error) {
+	content, err := ioutil.ReadFile(paths.ImageUrl(id))
+	if err != nil {
+		return nil, err
+	}
+	config := &ImageConfig{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func ListImageIDs() ([]string, error) {
+	files, err := ioutil.ReadDir(paths.ImagesUrl())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return ids, nil
+}
+
+// 返回镜像各层的目录，顺序和overlay的lowerdir一致，即最上层在前
+func ImageLowerDirs(id string) ([]string, error) {
+	config, err := ReadImageConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for i := len(config.Layers) - 1; i >= 0; i-- {
+		layerURL := paths.LayerUrl(config.Layers[i])
+		if _, err := os.Stat(layerURL); err != nil {
+			return nil, fmt.Errorf("layer %s of image %s error %v", config.Layers[i], id, err)
+		}
+		dirs = append(dirs, layerURL)
+	}
+	return dirs, nil
+}
+
+// 删除镜像的元数据，以及不再被任何镜像使用的镜像层
+func DeleteImage(id string) error {
+	if err := os.Remove(paths.ImageUrl(id)); err != nil {
+		return err
+	}
+	return removeUnusedLayers()
+}
+
+func removeUnusedLayers() error {
+	ids, err := ListImageIDs()
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, id := range ids {
+		config, err := ReadImageConfig(id)
+		if err != nil {
+			return err
+		}
+		for _, layer := range config.Layers {
+			used[layer] = true
+		}
+	}
+	layersURL := paths.LayersUrl()
+	files, err := ioutil.ReadDir(layersURL)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, file := range files {
+		// tmp-开头的是正在创建的层
+		if strings.HasPrefix(file.Name(), "tmp-") || used[file.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(layersURL, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 以前的镜像是数据目录下的 镜像名.tar，运行配置在 镜像名.json 中(build出来的镜像只有json)，
+// 把它导入镜像存储并打上 镜像名:latest 的tag；没有这样的镜像时返回空id
+func migrateLegacyImage(ref *Reference) (string, error) {
+	legacyConfigURL := filepath.Join(paths.Root(), ref.Name+".json")
+	legacyTarURL := filepath.Join(paths.Root(), ref.Name+".tar")
+	config := &ImageConfig{}
+	content, err := ioutil.ReadFile(legacyConfigURL)
+	if err == nil {
+		if err := json.Unmarshal(content, config); err != nil {
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(config.Layers) == 0 {
+		file, err := os.Open(legacyTarURL)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", nil
+			}
+			return "", err
+		}
+		defer file.Close()
+		layer, err := CreateLayerFromTar(file)
+		if err != nil {
+			return "", fmt.Errorf("import %s error %v", legacyTarURL, err)
+		}
+		config.Layers = []string{layer}
+	}
+	id, err := CreateImage(config)
+	if err != nil {
+		return "", err
+	}
+	return id, TagImage(id, ref)
+}
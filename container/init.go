@@ -1,19 +1,46 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// 父进程通过管道发送给init进程的启动参数
+type InitConfig struct {
+	Args       []string `json:"args"`
+	WorkingDir string   `json:"workingDir"`
+	Mounts     []Mount  `json:"mounts,omitempty"`
+	// 根文件系统只读，可写的目录需要通过-v或者--tmpfs挂载
+	ReadOnlyRoot bool `json:"readOnlyRoot,omitempty"`
+	// 除默认设备外通过--device传入的宿主机设备
+	Devices []Device `json:"devices,omitempty"`
+	// /dev/shm的大小，为0时使用DefaultShmSize
+	ShmSize int64 `json:"shmSize,omitempty"`
+}
+
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
+	pipe := os.NewFile(uintptr(3), "pipe")
+	// exec成功后管道随之关闭，父进程读到EOF就知道容器已经启动
+	syscall.CloseOnExec(int(pipe.Fd()))
+	err := runContainerInit(pipe)
+	// 走到这里说明没能exec用户程序，把错误发给父进程
+	pipe.Write([]byte(err.Error()))
+	return err
+}
+
+func runContainerInit(pipe *os.File) error {
+	initConfig, err := readInitConfig(pipe)
+	if err != nil {
+		return fmt.Errorf("run container get user command error %v", err)
+	}
+	cmdArray := initConfig.Args
 	if len(cmdArray) == 0 {
 		return fmt.Errorf("run container get user command error, cmdArray is nil")
 	}
@@ -22,7 +49,23 @@ func RunContainerInitProcess() error {
 	// defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
 	// syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
 	log.Info("start setUpMount")
-	setUpMount()
+	if err := setUpMount(initConfig); err != nil {
+		return err
+	}
+	if initConfig.WorkingDir != "" {
+		if err := os.MkdirAll(initConfig.WorkingDir, 0755); err != nil {
+			return fmt.Errorf("mkdir working dir %s error %v", initConfig.WorkingDir, err)
+		}
+		if err := syscall.Chdir(initConfig.WorkingDir); err != nil {
+			return fmt.Errorf("chdir %s error %v", initConfig.WorkingDir, err)
+		}
+	}
+	// 工作目录可能需要在rootfs中创建，所以最后再把根目录改成只读
+	if initConfig.ReadOnlyRoot {
+		if err := syscall.Mount("", "/", "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("remount / read-only error %v", err)
+		}
+	}
 
 	// 读到的第一个参数作为可执行文件的路径，进入容器后执行的第一个程序
 	path, err := exec.LookPath(cmdArray[0])
@@ -31,37 +74,67 @@ func RunContainerInitProcess() error {
 	}
 	log.Infof("Find path %s", path)
 	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
-		log.Errorf(err.Error())
+		return fmt.Errorf("exec %s error %v", path, err)
 	}
 	return nil
 }
 
-func readUserCommand() []string {
-	pipe := os.NewFile(uintptr(3), "pipe")
+// 参数中可能带有空格(如sh -c "xxx")，所以用json而不是空格拼接的字符串传递
+func readInitConfig(pipe *os.File) (*InitConfig, error) {
 	// fmt.Println("开始ReadAll")
 	msg, err := ioutil.ReadAll(pipe)
 	// fmt.Println("结束ReadAll")
 	if err != nil {
 		log.Errorf("init read pipe error %v", err)
+		return nil, err
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	initConfig := &InitConfig{}
+	if err := json.Unmarshal(msg, initConfig); err != nil {
+		return nil, err
+	}
+	return initConfig, nil
 }
 
-func setUpMount() {
+func setUpMount(initConfig *InitConfig) error {
 	pwd, err := os.Getwd()
 	if err != nil {
-		log.Errorf("Get current location error %v", err)
-		return
+		return fmt.Errorf("get current location error %v", err)
 	}
 	log.Infof("Current location is %s", pwd)
-	pivotRoot(pwd)
+	// 容器内的挂载不传播到宿主机，宿主机的根目录是shared时pivot_root也要求这样
+	if err := syscall.Mount("", "/", "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("make / rslave error %v", err)
+	}
+	if err := pivotRoot(pwd); err != nil {
+		return err
+	}
 	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-	syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
-
+	if err := syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), ""); err != nil {
+		return fmt.Errorf("mount proc error %v", err)
+	}
+	if err := syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
+		return fmt.Errorf("mount dev error %v", err)
+	}
+	if err := setUpDev(initConfig.Devices, initConfig.ShmSize, oldRootDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll("/sys", 0755); err != nil {
+		return err
+	}
+	// sysfs只读挂载，容器内不能修改宿主机的内核参数
+	if err := syscall.Mount("sysfs", "/sys", "sysfs", uintptr(defaultMountFlags|syscall.MS_RDONLY), ""); err != nil {
+		return fmt.Errorf("mount sysfs error %v", err)
+	}
+	// 旧的根目录卸载前还能访问宿主机上的路径，-v的挂载要在这时完成
+	if err := mountAll(initConfig.Mounts, oldRootDir); err != nil {
+		return err
+	}
+	return unmountOldRoot()
 }
 
+// pivot_root之后旧的根目录所在的位置
+const oldRootDir = "/.pivot_root"
+
 func pivotRoot(rootPath string) error {
 	// 为了使当前rootPath所在的rootfs和接下来要切换到的rootfs不在同一个rootfs里面，
 	// 这里需要把rootPath重新挂载一次，利用bind mount的方法：把相同内容换一个挂载点(rootfs)的挂载方法
@@ -70,7 +143,7 @@ func pivotRoot(rootPath string) error {
 	}
 
 	// 创建/rootPath/.pivot_root目录
-	pivotDir := filepath.Join(rootPath, ".pivot_root")
+	pivotDir := filepath.Join(rootPath, oldRootDir)
 	if _, err := os.Stat(pivotDir); os.IsNotExist(err) {
 		if err := os.Mkdir(pivotDir, 0777); err != nil {
 			fmt.Println(pivotDir, " not exist, creat one.")
@@ -88,13 +161,15 @@ func pivotRoot(rootPath string) error {
 	if err := syscall.Chdir("/"); err != nil {
 		return fmt.Errorf("chdir %v", err)
 	}
-	// 卸载掉原来的rootfs
-	pivotDir = filepath.Join("/", ".pivot_root")
+	return nil
+}
+
+// 卸载掉原来的rootfs
+func unmountOldRoot() error {
 	// MNT_DETACH是umount的一个参数，表示延迟卸载，立即断开文件系统与挂载点的连接，在挂载点空闲时才真正卸载
-	if err := syscall.Unmount(pivotDir, syscall.MNT_DETACH); err != nil {
+	if err := syscall.Unmount(oldRootDir, syscall.MNT_DETACH); err != nil {
 		return fmt.Errorf("umount pivot_root dir %v", err)
 	}
 	// 删除pivotDir目录
-	return os.Remove(pivotDir)
-	// return nil
+	return os.Remove(oldRootDir)
 }
@@ -0,0 +1,82 @@
+package container
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"example/mydocker/archive"
+	"example/mydocker/paths"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// 把tar流解压成一个镜像层，层的id是解压前tar流的sha256，相同内容的层只保存一份
+// 层目录是overlay的格式，.wh.文件会被转换成overlay的whiteout
+func CreateLayerFromTar(r io.Reader) (string, error) {
+	tmpURL, err := newLayerTempDir()
+	if err != nil {
+		return "", err
+	}
+	dr, err := archive.DecompressStream(r)
+	if err != nil {
+		os.RemoveAll(tmpURL)
+		return "", err
+	}
+	defer dr.Close()
+	hasher := sha256.New()
+	tee := io.TeeReader(dr, hasher)
+	if err := archive.Untar(tee, tmpURL, &archive.Options{OverlayWhiteout: true}); err != nil {
+		os.RemoveAll(tmpURL)
+		return "", err
+	}
+	// tar结束标记之后的填充数据也计入摘要
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		os.RemoveAll(tmpURL)
+		return "", err
+	}
+	return moveToLayer(tmpURL, hex.EncodeToString(hasher.Sum(nil)))
+}
+
+// 把overlay格式的目录(比如容器的upperdir)作为一个新的镜像层，目录会被移动到层目录下
+// 层的id是该目录打包成tar后的sha256
+func CreateLayerFromDir(dir string) (string, error) {
+	hasher := sha256.New()
+	if err := archive.Tar(dir, hasher, &archive.Options{OverlayWhiteout: true}); err != nil {
+		return "", err
+	}
+	return moveToLayer(dir, hex.EncodeToString(hasher.Sum(nil)))
+}
+
+func newLayerTempDir() (string, error) {
+	layersURL := paths.LayersUrl()
+	if err := os.MkdirAll(layersURL, 0755); err != nil {
+		return "", err
+	}
+	tmpURL, err := ioutil.TempDir(layersURL, "tmp-")
+	if err != nil {
+		return "", err
+	}
+	// TempDir创建的目录权限是0700，层的根目录需要所有人可读
+	return tmpURL, os.Chmod(tmpURL, 0755)
+}
+
+func moveToLayer(dir string, id string) (string, error) {
+	layerURL := paths.LayerUrl(id)
+	if _, err := os.Stat(layerURL); err == nil {
+		// 已经有相同内容的层了，直接复用
+		return id, os.RemoveAll(dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(layerURL), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(dir, layerURL); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func LayerExists(id string) bool {
+	_, err := os.Stat(paths.LayerUrl(id))
+	return err == nil
+}
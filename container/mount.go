@@ -0,0 +1,253 @@
+package container
+
+import (
+	"example/mydocker/volume"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	MountTypeBind   = "bind"
+	MountTypeVolume = "volume"
+	MountTypeTmpfs  = "tmpfs"
+)
+
+// 容器的一个挂载，由init进程在容器的mount namespace中完成
+// bind类型的Source是宿主机上的路径；volume类型的Name是命名卷的名字，Source是卷的数据目录；
+// tmpfs类型没有Source，Options是tmpfs的挂载参数
+type Mount struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	Options     string `json:"options,omitempty"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+	Propagation string `json:"propagation,omitempty"`
+}
+
+var propagationModes = map[string]uintptr{
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+}
+
+// 解析 -v 参数，格式为 source:destination[:options]
+// source是绝对路径时是bind挂载，否则是命名卷；options用逗号分隔，支持ro、rw和挂载传播模式
+func ParseVolume(spec string) (*Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid volume %q, expect source:destination[:options]", spec)
+	}
+	m := &Mount{Destination: filepath.Clean(parts[1])}
+	if !filepath.IsAbs(m.Destination) || m.Destination == "/" {
+		return nil, fmt.Errorf("invalid volume destination %s, it must be an absolute path other than /", parts[1])
+	}
+	if filepath.IsAbs(parts[0]) {
+		m.Type = MountTypeBind
+		m.Source = filepath.Clean(parts[0])
+	} else if volume.ValidName(parts[0]) {
+		m.Type = MountTypeVolume
+		m.Name = parts[0]
+	} else {
+		return nil, fmt.Errorf("invalid volume source %s, it must be an absolute path or a volume name", parts[0])
+	}
+	if len(parts) == 3 {
+		for _, opt := range strings.Split(parts[2], ",") {
+			switch {
+			case opt == "ro":
+				m.ReadOnly = true
+			case opt == "rw":
+				m.ReadOnly = false
+			case propagationModes[opt] != 0:
+				if m.Propagation != "" {
+					return nil, fmt.Errorf("invalid volume %q, duplicate propagation mode", spec)
+				}
+				m.Propagation = opt
+			default:
+				return nil, fmt.Errorf("invalid volume %q, unknown option %s", spec, opt)
+			}
+		}
+	}
+	return m, nil
+}
+
+// 解析 --tmpfs 参数，格式为 destination[:options]，options是tmpfs的挂载参数，如size=64m,mode=1777
+func ParseTmpfs(spec string) (*Mount, error) {
+	dest, options := spec, ""
+	if i := strings.Index(spec, ":"); i != -1 {
+		dest, options = spec[:i], spec[i+1:]
+	}
+	m := &Mount{Type: MountTypeTmpfs, Destination: filepath.Clean(dest)}
+	if !filepath.IsAbs(m.Destination) || m.Destination == "/" {
+		return nil, fmt.Errorf("invalid tmpfs destination %s, it must be an absolute path other than /", dest)
+	}
+	var data []string
+	for _, opt := range strings.Split(options, ",") {
+		switch opt {
+		case "":
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+			m.ReadOnly = false
+		default:
+			data = append(data, opt)
+		}
+	}
+	m.Options = strings.Join(data, ",")
+	return m, nil
+}
+
+func (m *Mount) String() string {
+	source := m.Source
+	switch m.Type {
+	case MountTypeVolume:
+		source = m.Name
+	case MountTypeTmpfs:
+		source = "tmpfs"
+	}
+	mode := "rw"
+	if m.ReadOnly {
+		mode = "ro"
+	}
+	if m.Propagation != "" {
+		mode += "," + m.Propagation
+	}
+	if m.Options != "" {
+		mode += "," + m.Options
+	}
+	return source + ":" + m.Destination + ":" + mode
+}
+
+// 在父进程中准备挂载的源：创建命名卷，创建不存在的bind目录，并解析源路径中的符号链接，
+// init进程在pivot_root之后通过旧的根目录访问这些路径，宿主机上的绝对符号链接在那里会解析错
+func PrepareMounts(mounts []Mount) error {
+	for i := range mounts {
+		m := &mounts[i]
+		switch m.Type {
+		case MountTypeVolume:
+			v, err := volume.Create(m.Name)
+			if err != nil {
+				return fmt.Errorf("create volume %s error %v", m.Name, err)
+			}
+			m.Source = v.Mountpoint
+		case MountTypeBind:
+			if _, err := os.Stat(m.Source); os.IsNotExist(err) {
+				if err := os.MkdirAll(m.Source, 0777); err != nil {
+					return fmt.Errorf("mkdir %s error %v", m.Source, err)
+				}
+			}
+		default:
+			continue
+		}
+		source, err := filepath.EvalSymlinks(m.Source)
+		if err != nil {
+			return fmt.Errorf("resolve mount source %s error %v", m.Source, err)
+		}
+		m.Source = source
+	}
+	return nil
+}
+
+// 在容器的mount namespace中完成挂载，此时已经pivot_root，oldRoot是旧的根目录所在的位置
+func mountAll(mounts []Mount, oldRoot string) error {
+	for i := range mounts {
+		if err := mountOne(&mounts[i], oldRoot); err != nil {
+			return fmt.Errorf("mount %s error %v", &mounts[i], err)
+		}
+	}
+	return nil
+}
+
+func mountOne(m *Mount, oldRoot string) error {
+	if m.Type == MountTypeTmpfs {
+		if err := os.MkdirAll(m.Destination, 0755); err != nil {
+			return err
+		}
+		flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+		if m.ReadOnly {
+			flags |= syscall.MS_RDONLY
+		}
+		return syscall.Mount("tmpfs", m.Destination, "tmpfs", flags, m.Options)
+	}
+
+	source := filepath.Join(oldRoot, m.Source)
+	if err := createMountTarget(source, m.Destination); err != nil {
+		return err
+	}
+	if err := syscall.Mount(source, m.Destination, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	// bind挂载时会忽略MS_RDONLY，只读需要再remount一次
+	if m.ReadOnly {
+		if err := remountReadOnly(m.Destination); err != nil {
+			return err
+		}
+	}
+	if m.Propagation != "" {
+		if err := syscall.Mount("", m.Destination, "", propagationModes[m.Propagation], ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remount会忽略MS_REC，源目录下的子挂载要一个一个remount成只读
+// remount时没有指定的nosuid、nodev等标志会被清除，所以带上每个挂载原来的标志
+func remountReadOnly(dest string) error {
+	mountPoints, err := subMounts(dest)
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mountPoints {
+		var st unix.Statfs_t
+		if err := unix.Statfs(mountPoint, &st); err != nil {
+			return fmt.Errorf("statfs %s error %v", mountPoint, err)
+		}
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		for stFlag, msFlag := range statfsMountFlags {
+			if st.Flags&stFlag != 0 {
+				flags |= msFlag
+			}
+		}
+		if err := syscall.Mount("", mountPoint, "", flags, ""); err != nil {
+			return fmt.Errorf("remount %s read-only error %v", mountPoint, err)
+		}
+	}
+	return nil
+}
+
+var statfsMountFlags = map[int64]uintptr{
+	unix.ST_NOSUID:     syscall.MS_NOSUID,
+	unix.ST_NODEV:      syscall.MS_NODEV,
+	unix.ST_NOEXEC:     syscall.MS_NOEXEC,
+	unix.ST_NOATIME:    syscall.MS_NOATIME,
+	unix.ST_NODIRATIME: syscall.MS_NODIRATIME,
+	unix.ST_RELATIME:   syscall.MS_RELATIME,
+}
+
+// 创建挂载点：源是文件时挂载点也是文件
+func createMountTarget(source string, target string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
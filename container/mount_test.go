@@ -0,0 +1,33 @@
+package container
+
+import "testing"
+
+func TestParseVolume(t *testing.T) {
+	for spec, expected := range map[string]Mount{
+		"/data:/data":                  {Type: MountTypeBind, Source: "/data", Destination: "/data"},
+		"/data/:/app/data/:ro":         {Type: MountTypeBind, Source: "/data", Destination: "/app/data", ReadOnly: true},
+		"cache:/cache:rw,rshared":      {Type: MountTypeVolume, Name: "cache", Destination: "/cache", Propagation: "rshared"},
+		"db-data:/var/lib/db:ro,slave": {Type: MountTypeVolume, Name: "db-data", Destination: "/var/lib/db", ReadOnly: true, Propagation: "slave"},
+	} {
+		m, err := ParseVolume(spec)
+		if err != nil {
+			t.Errorf("parse %s error: %v", spec, err)
+			continue
+		}
+		if *m != expected {
+			t.Errorf("parse %s: expect %+v, got %+v", spec, expected, *m)
+		}
+	}
+	tmpfs, err := ParseTmpfs("/run:size=64m,mode=1777,ro")
+	if err != nil {
+		t.Fatalf("parse tmpfs error: %v", err)
+	}
+	if *tmpfs != (Mount{Type: MountTypeTmpfs, Destination: "/run", Options: "size=64m,mode=1777", ReadOnly: true}) {
+		t.Errorf("unexpected tmpfs mount %+v", *tmpfs)
+	}
+	for _, spec := range []string{"/data", "/data:relative", "/data:/", "./data:/data", "/a:/b:rx", "/a:/b:shared,slave", "/a:/b:ro:x"} {
+		if _, err := ParseVolume(spec); err == nil {
+			t.Errorf("expect error for %q", spec)
+		}
+	}
+}
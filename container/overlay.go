@@ -0,0 +1,54 @@
+package container
+
+import (
+	"example/mydocker/archive"
+	"example/mydocker/paths"
+	"fmt"
+	"io"
+)
+
+// overlay驱动，镜像的各层作为lowerdir，容器的读写层作为upperdir，是默认的驱动
+// 读写层就是容器的修改，其中的whiteout和opaque目录在打包时转换成.wh.文件
+type OverlayDriver struct {
+}
+
+func (d *OverlayDriver) Name() string {
+	return "overlay"
+}
+
+func (d *OverlayDriver) Create(containerName string, imageID string, storageOpts *StorageOptions) error {
+	return CreatWriteLayer(containerName, storageOpts)
+}
+
+func (d *OverlayDriver) Mount(containerName string, imageID string) error {
+	return CreatMountPoint(containerName, imageID)
+}
+
+func (d *OverlayDriver) Unmount(containerName string) error {
+	return DeleteMountPoint(containerName)
+}
+
+func (d *OverlayDriver) Remove(containerName string) error {
+	return DeleteWriteLayer(containerName)
+}
+
+func (d *OverlayDriver) Diff(containerName string, imageID string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Tar(paths.WriteLayerUrl(containerName), pw, &archive.Options{OverlayWhiteout: true}))
+	}()
+	return pr, nil
+}
+
+func (d *OverlayDriver) Changes(containerName string, imageID string) ([]archive.Change, error) {
+	lowerURLs, err := ImageLowerDirs(imageID)
+	if err != nil {
+		return nil, fmt.Errorf("get image %s layers error %v", imageID, err)
+	}
+	return archive.OverlayChanges(lowerURLs, paths.WriteLayerUrl(containerName))
+}
+
+// 直接解压到upperdir中，.wh.文件还原成overlay的whiteout
+func (d *OverlayDriver) ApplyDiff(containerName string, diff io.Reader) error {
+	return archive.Untar(diff, paths.WriteLayerUrl(containerName), &archive.Options{OverlayWhiteout: true})
+}
@@ -0,0 +1,212 @@
+package container
+
+import (
+	"encoding/json"
+	"example/mydocker/paths"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const DefaultTag = "latest"
+
+var (
+	// 镜像名由/分隔的小写字母数字组成，第一段可以是带端口的registry地址，如localhost:5000/busybox
+	pathComponent = `[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*`
+	domain        = `(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?`
+	nameRegexp    = regexp.MustCompile(`^(?:` + domain + `/)?` + pathComponent + `(?:/` + pathComponent + `)*$`)
+	tagRegexp     = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp  = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	idRegexp      = regexp.MustCompile(`^[a-f0-9]{4,64}$`)
+)
+
+// 镜像引用，格式为 name:tag 或者 name@sha256:xxx
+type Reference struct {
+	Name   string
+	Tag    string
+	Digest string
+}
+
+func ParseReference(s string) (*Reference, error) {
+	ref := &Reference{}
+	name := s
+	if i := strings.Index(name, "@"); i != -1 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !digestRegexp.MatchString(ref.Digest) {
+			return nil, fmt.Errorf("invalid digest %s", ref.Digest)
+		}
+	}
+	// 最后一个/之后的冒号才是tag，前面的冒号是registry的端口
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("invalid tag %s", ref.Tag)
+		}
+	}
+	if !nameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid reference format %s", s)
+	}
+	ref.Name = name
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+func (r *Reference) String() string {
+	s := r.Name
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// 把用户输入的镜像解析成镜像id，支持 name:tag、name@sha256:xxx 和镜像id(可以只写前缀)
+// name@sha256:xxx 中的digest是registry中manifest的digest，pull时记录在索引中
+func ResolveImage(s string) (string, error) {
+	ref, err := ParseReference(s)
+	if err == nil {
+		repos, err := loadRepositories()
+		if err != nil {
+			return "", err
+		}
+		key := ref.String()
+		if ref.Digest != "" {
+			// 同时有tag和digest时以digest为准
+			key = (&Reference{Name: ref.Name, Digest: ref.Digest}).String()
+		}
+		if id, ok := repos[key]; ok {
+			return id, nil
+		}
+	}
+	if idRegexp.MatchString(s) {
+		if id, err := resolveImageID(s); err == nil {
+			return id, nil
+		}
+	}
+	if ref != nil && ref.Tag == DefaultTag {
+		// 以前的镜像直接以 镜像名.tar 的形式放在数据目录下，第一次使用时迁移过来
+		if id, err := migrateLegacyImage(ref); err != nil || id != "" {
+			return id, err
+		}
+	}
+	return "", fmt.Errorf("no such image: %s", s)
+}
+
+// 根据镜像id的前缀查找镜像，前缀匹配到多个镜像时报错
+func resolveImageID(prefix string) (string, error) {
+	ids, err := ListImageIDs()
+	if err != nil {
+		return "", err
+	}
+	var matched []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			matched = append(matched, id)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("no such image: %s", prefix)
+	case 1:
+		return matched[0], nil
+	}
+	return "", fmt.Errorf("image id prefix %s is ambiguous", prefix)
+}
+
+func TagImage(id string, ref *Reference) error {
+	if ref.Digest != "" {
+		return fmt.Errorf("can not tag with a digest reference %s", ref)
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	repos[ref.String()] = id
+	return saveRepositories(repos)
+}
+
+// 记录从registry拉取的镜像的manifest digest，之后可以用 name@digest 引用这个镜像
+func SetImageDigest(id string, name string, digest string) error {
+	ref := &Reference{Name: name, Digest: digest}
+	if _, err := ParseReference(ref.String()); err != nil {
+		return err
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	repos[ref.String()] = id
+	return saveRepositories(repos)
+}
+
+func UntagImage(ref *Reference) error {
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	if _, ok := repos[ref.String()]; !ok {
+		return fmt.Errorf("no such image: %s", ref)
+	}
+	delete(repos, ref.String())
+	return saveRepositories(repos)
+}
+
+// 返回指向该镜像的所有 name:tag 和 name@digest，已排序
+func ImageReferences(id string) ([]string, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for ref, imageID := range repos {
+		if imageID == id {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs, nil
+}
+
+// 返回所有的镜像引用，key是 name:tag 或者 name@digest，value是镜像id
+func ListReferences() (map[string]string, error) {
+	return loadRepositories()
+}
+
+func loadRepositories() (map[string]string, error) {
+	repos := map[string]string{}
+	content, err := ioutil.ReadFile(paths.RepositoriesUrl())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repos, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &repos); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+func saveRepositories(repos map[string]string) error {
+	jsonBytes, err := json.Marshal(repos)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(paths.RepositoriesUrl()), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免写到一半时索引损坏
+	tmpURL := paths.RepositoriesUrl() + ".tmp"
+	if err := ioutil.WriteFile(tmpURL, jsonBytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpURL, paths.RepositoriesUrl())
+}
@@ -0,0 +1,56 @@
+package container
+
+import (
+	"example/mydocker/paths"
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	for input, expected := range map[string]Reference{
+		"busybox":                               {Name: "busybox", Tag: "latest"},
+		"busybox:1.36":                          {Name: "busybox", Tag: "1.36"},
+		"localhost:5000/library/app":            {Name: "localhost:5000/library/app", Tag: "latest"},
+		"localhost:5000/app:v1":                 {Name: "localhost:5000/app", Tag: "v1"},
+		"app@sha256:" + strings.Repeat("a", 64): {Name: "app", Digest: "sha256:" + strings.Repeat("a", 64)},
+	} {
+		ref, err := ParseReference(input)
+		if err != nil {
+			t.Errorf("parse %s error: %v", input, err)
+			continue
+		}
+		if *ref != expected {
+			t.Errorf("parse %s: expect %+v, got %+v", input, expected, *ref)
+		}
+	}
+	for _, input := range []string{"", "Busybox", "app:", "app:bad/tag", "app@sha256:123"} {
+		if _, err := ParseReference(input); err == nil {
+			t.Errorf("expect error for %q", input)
+		}
+	}
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	if err := paths.Load("", t.TempDir(), t.TempDir(), ""); err != nil {
+		t.Fatal(err)
+	}
+	id := strings.Repeat("1", 64)
+	manifest := "sha256:" + strings.Repeat("a", 64)
+	if err := SetImageDigest(id, "localhost:5000/app", manifest); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"localhost:5000/app@" + manifest, "localhost:5000/app:v1@" + manifest} {
+		if resolved, err := ResolveImage(s); err != nil || resolved != id {
+			t.Errorf("resolve %s: expect %s, got %s %v", s, id, resolved, err)
+		}
+	}
+	// digest只在记录它的仓库中有效，也不是镜像id
+	for _, s := range []string{"other@" + manifest, "localhost:5000/app@sha256:" + id, "localhost:5000/app"} {
+		if _, err := ResolveImage(s); err == nil {
+			t.Errorf("expect error for %s", s)
+		}
+	}
+	if err := SetImageDigest(id, "app", "sha256:123"); err == nil {
+		t.Errorf("expect error for invalid digest")
+	}
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"example/mydocker/paths"
+	"example/mydocker/quota"
+	"example/mydocker/units"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// 容器读写层的存储选项，由--storage-opt指定
+type StorageOptions struct {
+	// 读写层的大小上限，0表示不限制
+	Size int64
+}
+
+// 解析--storage-opt参数，格式为key=value，目前只支持size，如size=10G
+func ParseStorageOpts(opts []string) (*StorageOptions, error) {
+	options := &StorageOptions{}
+	for _, opt := range opts {
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid storage option %q, expect key=value", opt)
+		}
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "size":
+			size, err := units.ParseSize(strings.TrimSpace(parts[1]))
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("invalid storage size %s", parts[1])
+			}
+			options.Size = size
+		default:
+			return nil, fmt.Errorf("unknown storage option %s", parts[0])
+		}
+	}
+	return options, nil
+}
+
+// 限制读写层和overlay的workdir的总大小，写满后容器中得到ENOSPC
+// 数据目录所在的文件系统开启了project quota时给两个目录设置同一个project的配额，
+// 否则为容器创建一个size大小的ext4镜像，overlay的upperdir和workdir都放在其中
+func setUpStorageQuota(containerName string, size int64) error {
+	writeURL := paths.WriteLayerUrl(containerName)
+	workURL := paths.WorkLayerUrl(containerName)
+	if quota.ProjectQuotaSupported(paths.WriteLayersUrl()) {
+		log.Debugf("limit write layer of %s to %d bytes with project quota", containerName, size)
+		return quota.SetProjectQuota(paths.WriteLayersUrl(), []string{writeURL, workURL}, size)
+	}
+
+	storageURL := paths.StorageUrl(containerName)
+	if err := os.MkdirAll(storageURL, 0700); err != nil {
+		return fmt.Errorf("mkdir storageURL %s error %v", storageURL, err)
+	}
+	log.Debugf("limit write layer of %s to %d bytes with loopback image", containerName, size)
+	if err := quota.MountLoopback(storageURL+".img", storageURL, size); err != nil {
+		return err
+	}
+	upperURL := filepath.Join(storageURL, "upper")
+	for _, dir := range []string{upperURL, filepath.Join(storageURL, "work")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("mkdir %s error %v", dir, err)
+		}
+	}
+	// overlay要求upperdir和workdir在同一个挂载下，不能分别bind挂载，只把upperdir挂到读写层上，
+	// commit和df等直接读读写层的地方不用关心读写层在哪里
+	if err := unix.Mount(upperURL, writeURL, "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s to %s error %v", upperURL, writeURL, err)
+	}
+	return nil
+}
+
+// overlay使用的upperdir和workdir，读写层在loop设备上时直接使用镜像中的目录
+func overlayDirs(containerName string) (string, string) {
+	storageURL := paths.StorageUrl(containerName)
+	if _, err := os.Stat(filepath.Join(storageURL, "upper")); err == nil {
+		return filepath.Join(storageURL, "upper"), filepath.Join(storageURL, "work")
+	}
+	return paths.WriteLayerUrl(containerName), paths.WorkLayerUrl(containerName)
+}
+
+// 卸载setUpStorageQuota中挂载的目录和镜像并删除镜像，可以重复调用
+// project quota的配额不用清理，目录删除后占用的空间就释放了，id会分配给之后的容器
+func removeStorageQuota(containerName string) error {
+	storageURL := paths.StorageUrl(containerName)
+	for _, target := range []string{paths.WriteLayerUrl(containerName), storageURL} {
+		mounted, err := isMountPoint(target)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			continue
+		}
+		if err := unix.Unmount(target, 0); err != nil {
+			return fmt.Errorf("umount %s error %v", target, err)
+		}
+	}
+	if err := os.Remove(storageURL); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove storageURL %s error %v", storageURL, err)
+	}
+	if err := os.Remove(storageURL + ".img"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove storage image %s error %v", storageURL+".img", err)
+	}
+	return nil
+}
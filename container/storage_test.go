@@ -0,0 +1,18 @@
+package container
+
+import "testing"
+
+func TestParseStorageOpts(t *testing.T) {
+	opts, err := ParseStorageOpts([]string{"size=10G"})
+	if err != nil || opts.Size != 10<<30 {
+		t.Errorf("unexpected options %+v %v", opts, err)
+	}
+	if opts, err := ParseStorageOpts(nil); err != nil || opts.Size != 0 {
+		t.Errorf("unexpected options %+v %v", opts, err)
+	}
+	for _, opt := range []string{"size", "size=", "size=0", "size=abc", "foo=1"} {
+		if _, err := ParseStorageOpts([]string{opt}); err == nil {
+			t.Errorf("expect error for %q", opt)
+		}
+	}
+}
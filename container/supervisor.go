@@ -0,0 +1,445 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"example/mydocker/logger"
+	"example/mydocker/paths"
+	"example/mydocker/term"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// 一条日志的最大长度，更长的行拆成多条，只有最后一条带换行符
+	maxLogLineSize = 16 * 1024
+	// attach的客户端读得太慢时，容器的输出最多为它等待这么久，之后断开这个客户端
+	attachWriteTimeout = 5 * time.Second
+)
+
+// 启动容器的supervisor进程，由它持有容器的标准输入输出：输出交给日志驱动并转发给attach的客户端，
+// attach的客户端的输入写给容器；tty时分配伪终端，slave作为容器的控制终端，master交给supervisor，
+// 否则标准输出和标准错误接到管道上，openStdin时标准输入也接到管道上，不打开时容器的标准输入是/dev/null
+// supervisor在单独的会话中运行，mydocker命令退出后仍然工作，容器进程都退出、输出关闭后自己退出
+// 日志驱动创建失败(比如连不上syslog)时返回错误；返回的函数在容器进程启动之后调用，关闭父进程中容器一端的副本
+func StartSupervisor(cmd *exec.Cmd, containerID string, containerName string, logConfig *logger.Config, tty bool, openStdin bool) (func(), error) {
+	// supervisorFiles交给supervisor，containerFiles交给容器，父进程中的副本都要关闭
+	var supervisorFiles, containerFiles []*os.File
+	closeFiles := func(files []*os.File) {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	pipe := func() (*os.File, *os.File, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeFiles(supervisorFiles)
+			closeFiles(containerFiles)
+			return nil, nil, fmt.Errorf("new pipe error %v", err)
+		}
+		return r, w, nil
+	}
+	if tty {
+		master, slave, err := term.OpenPty()
+		if err != nil {
+			return nil, err
+		}
+		supervisorFiles, containerFiles = []*os.File{master}, []*os.File{slave}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		// 新的会话中，标准输入就是控制终端
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+		// 先设置好窗口大小，容器中的程序启动时就能拿到
+		if ws, err := term.GetWinsize(os.Stdin.Fd()); err == nil {
+			if err := term.SetWinsize(master.Fd(), ws); err != nil {
+				log.Warnf("set console size error %v", err)
+			}
+		}
+	} else {
+		for i := 0; i < 2; i++ {
+			r, w, err := pipe()
+			if err != nil {
+				return nil, err
+			}
+			supervisorFiles, containerFiles = append(supervisorFiles, r), append(containerFiles, w)
+		}
+		cmd.Stdout, cmd.Stderr = containerFiles[0], containerFiles[1]
+		if openStdin {
+			r, w, err := pipe()
+			if err != nil {
+				return nil, err
+			}
+			supervisorFiles, containerFiles = append(supervisorFiles, w), append(containerFiles, r)
+			cmd.Stdin = r
+		}
+	}
+	defer closeFiles(supervisorFiles)
+	// 和init进程的管道一样，supervisor准备好日志驱动和attach socket后关闭管道，失败时先写回错误
+	statusRead, statusWrite, err := pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer statusRead.Close()
+
+	// supervisor不一定能拿到命令行上的全局参数，存储目录显式地传过去
+	args := []string{"--root", paths.Root(), "--state-dir", paths.StateDir(), "supervise", "--id", containerID, "--log-driver", logConfig.Driver}
+	var keys []string
+	for key := range logConfig.Opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--log-opt", key+"="+logConfig.Opts[key])
+	}
+	if tty {
+		args = append(args, "--tty")
+	}
+	if openStdin {
+		args = append(args, "--stdin")
+	}
+	supervisor := exec.Command("/proc/self/exe", append(args, containerName)...)
+	supervisor.ExtraFiles = append([]*os.File{statusWrite}, supervisorFiles...)
+	supervisor.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = supervisor.Start()
+	statusWrite.Close()
+	if err != nil {
+		closeFiles(containerFiles)
+		return nil, fmt.Errorf("start supervisor error %v", err)
+	}
+	log.Debugf("container %s supervisor pid %d", containerName, supervisor.Process.Pid)
+	supervisor.Process.Release()
+	reply, err := ioutil.ReadAll(statusRead)
+	if err == nil && len(reply) > 0 {
+		err = fmt.Errorf("%s", reply)
+	}
+	if err != nil {
+		closeFiles(containerFiles)
+		return nil, err
+	}
+	return func() { closeFiles(containerFiles) }, nil
+}
+
+// supervisor进程的入口，fd 3用来返回启动结果；tty时fd 4是伪终端的master，
+// 否则fd 4和5是容器的标准输出和标准错误，openStdin时fd 6是容器的标准输入
+func RunSupervisor(containerID string, containerName string, logDriver string, logOpts []string, tty bool, openStdin bool) error {
+	status := os.NewFile(uintptr(3), "status-pipe")
+	outputs := map[string]*os.File{}
+	var console, stdin *os.File
+	if tty {
+		console = os.NewFile(uintptr(4), "console")
+		outputs[logger.Stdout] = console
+		if openStdin {
+			stdin = console
+		}
+	} else {
+		outputs[logger.Stdout] = os.NewFile(uintptr(4), "stdout-pipe")
+		outputs[logger.Stderr] = os.NewFile(uintptr(5), "stderr-pipe")
+		if openStdin {
+			stdin = os.NewFile(uintptr(6), "stdin-pipe")
+		}
+	}
+
+	l, server, err := setUpSupervisor(containerID, containerName, logDriver, logOpts, console, stdin)
+	if err != nil {
+		status.Write([]byte(err.Error()))
+		status.Close()
+		return err
+	}
+	status.Close()
+	defer l.Close()
+
+	var mu sync.Mutex
+	logMessage := func(msg *logger.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return l.Log(msg)
+	}
+	var wg sync.WaitGroup
+	for stream, output := range outputs {
+		wg.Add(1)
+		go func(stream string, output *os.File) {
+			defer wg.Done()
+			copyOutput(output, stream, server, &logWriter{stream: stream, log: logMessage})
+		}(stream, output)
+	}
+	wg.Wait()
+	// 把剩下的输出发给客户端后再断开，客户端读到EOF就知道容器退出了
+	server.close()
+	return nil
+}
+
+func setUpSupervisor(containerID string, containerName string, logDriver string, logOpts []string, console *os.File, stdin *os.File) (logger.Logger, *attachServer, error) {
+	logConfig, err := logger.ParseConfig(logDriver, logOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	driver, err := logger.GetDriver(logConfig.Driver)
+	if err != nil {
+		return nil, nil, err
+	}
+	containerDir := paths.ContainerUrl(containerName)
+	if err := os.MkdirAll(containerDir, 0622); err != nil {
+		return nil, nil, fmt.Errorf("mkdir containerDir:%s error %v", containerDir, err)
+	}
+	l, err := driver.New(&logger.Info{
+		ContainerID:   containerID,
+		ContainerName: containerName,
+		LogPath:       filepath.Join(containerDir, LogName),
+		Opts:          logConfig.Opts,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	server, err := newAttachServer(filepath.Join(containerDir, AttachSocketName), console, stdin)
+	if err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	return l, server, nil
+}
+
+// 读取容器的一路输出，原样转发给attach的客户端，同时按行写入日志，读到EOF时返回
+// 伪终端的slave都关闭后读master返回EIO，同样当作结束
+func copyOutput(r io.Reader, stream string, server *attachServer, w *logWriter) {
+	kind := frameStdout
+	if stream == logger.Stderr {
+		kind = frameStderr
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			data := append([]byte{}, buf[:n]...)
+			server.broadcast(kind, data)
+			w.Write(data)
+		}
+		if err != nil {
+			if err != io.EOF && !isEIO(err) {
+				log.Errorf("read container %s error %v", stream, err)
+			}
+			w.flush()
+			return
+		}
+	}
+}
+
+func isEIO(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == syscall.EIO
+}
+
+// 把容器的一路输出按行拆成日志，不完整的行留到下次，超过maxLogLineSize时拆开
+// 写日志失败时只记录错误，不能因此停止读取容器的输出
+type logWriter struct {
+	stream string
+	buf    []byte
+	log    func(*logger.Message) error
+}
+
+func (w *logWriter) Write(p []byte) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 || i >= maxLogLineSize {
+			if len(w.buf) < maxLogLineSize {
+				return
+			}
+			i = maxLogLineSize - 1
+		}
+		w.emit(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// 输出结束时把最后不完整的行也写入日志
+func (w *logWriter) flush() {
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *logWriter) emit(line []byte) {
+	msg := &logger.Message{Line: append([]byte{}, line...), Stream: w.stream, Time: time.Now().UTC()}
+	if err := w.log(msg); err != nil {
+		log.Errorf("write %s log error %v", w.stream, err)
+	}
+}
+
+// supervisor的attach socket，每个连接上的客户端都收到容器的输出，
+// 客户端发来的输入写给容器的标准输入，窗口大小的变化设置到伪终端上
+type attachServer struct {
+	listener *net.UnixListener
+	// tty时是伪终端的master，否则为nil
+	console *os.File
+	// 容器的标准输入，没有打开时为nil
+	stdin   *os.File
+	mu      sync.Mutex
+	clients map[*attachClient]bool
+	closed  bool
+	// 等待所有客户端的输出发送完
+	wg sync.WaitGroup
+}
+
+type attachClient struct {
+	conn *net.UnixConn
+	out  chan []byte
+	// 客户端断开时关闭，out不关闭，broadcast不持有锁发送时也不会往关闭的channel中写
+	done chan struct{}
+}
+
+func newAttachServer(socket string, console *os.File, stdin *os.File) (*attachServer, error) {
+	// 容器重名时可能留下了旧的socket
+	os.Remove(socket)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("listen %s error %v", socket, err)
+	}
+	s := &attachServer{listener: listener, console: console, stdin: stdin, clients: map[*attachClient]bool{}}
+	go s.serve()
+	return s, nil
+}
+
+func (s *attachServer) serve() {
+	for {
+		conn, err := s.listener.AcceptUnix()
+		if err != nil {
+			return
+		}
+		c := &attachClient{conn: conn, out: make(chan []byte, 256), done: make(chan struct{})}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.clients[c] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.writeOutput(c)
+		go s.readInput(c)
+	}
+}
+
+func (s *attachServer) writeOutput(c *attachClient) {
+	defer s.wg.Done()
+	defer c.conn.Close()
+	write := func(frame []byte) {
+		if _, err := c.conn.Write(frame); err != nil {
+			s.remove(c)
+		}
+	}
+	for {
+		select {
+		case frame := <-c.out:
+			write(frame)
+		case <-c.done:
+			// 断开之前把已经排队的输出发完
+			for {
+				select {
+				case frame := <-c.out:
+					write(frame)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *attachServer) readInput(c *attachClient) {
+	for {
+		kind, data, err := readFrame(c.conn)
+		if err != nil {
+			s.remove(c)
+			return
+		}
+		switch kind {
+		case frameStdin:
+			if s.stdin != nil {
+				s.stdin.Write(data)
+			}
+		case frameResize:
+			var ws term.Winsize
+			if s.console != nil && json.Unmarshal(data, &ws) == nil {
+				term.SetWinsize(s.console.Fd(), &ws)
+			}
+		case frameCloseStdin:
+			// 伪终端的输入由容器中的终端处理，不能关闭
+			if s.console == nil && s.stdin != nil {
+				s.stdin.Close()
+			}
+		}
+	}
+}
+
+func (s *attachServer) broadcast(kind byte, data []byte) {
+	frame := encodeFrame(kind, data)
+	// 不持有锁等待很慢的客户端，否则其他客户端的连接和断开都要等它
+	s.mu.Lock()
+	clients := make([]*attachClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	for _, c := range clients {
+		select {
+		case c.out <- frame:
+			continue
+		case <-c.done:
+			continue
+		default:
+		}
+		timer := time.NewTimer(attachWriteTimeout)
+		select {
+		case c.out <- frame:
+		case <-c.done:
+		case <-timer.C:
+			log.Warnf("attach client too slow, disconnect it")
+			s.remove(c)
+		}
+		timer.Stop()
+	}
+}
+
+func (s *attachServer) remove(c *attachClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(c)
+}
+
+func (s *attachServer) removeLocked(c *attachClient) {
+	if s.clients[c] {
+		delete(s.clients, c)
+		close(c.done)
+	}
+}
+
+// 不再接受新的连接，已经连接的客户端收完输出后断开
+func (s *attachServer) close() {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.clients {
+		s.removeLocked(c)
+	}
+	s.mu.Unlock()
+	s.listener.Close()
+	s.wg.Wait()
+}
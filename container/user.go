@@ -0,0 +1,117 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 容器中的用户，-u指定的用户名或组名在容器的/etc/passwd和/etc/group中查找
+type User struct {
+	Uid int
+	Gid int
+	// 附加组，只指定用户时是/etc/group中包含这个用户的组
+	Groups []int
+	Home   string
+}
+
+// user是用户名或uid，后面可以跟:组名或gid；uid和gid可以不在passwd和group中
+func LookupUser(user string) (*User, error) {
+	var passwd, group io.Reader
+	if file, err := os.Open("/etc/passwd"); err == nil {
+		defer file.Close()
+		passwd = file
+	}
+	if file, err := os.Open("/etc/group"); err == nil {
+		defer file.Close()
+		group = file
+	}
+	return parseUser(user, passwd, group)
+}
+
+func parseUser(user string, passwd io.Reader, group io.Reader) (*User, error) {
+	name, groupName, hasGroup := strings.Cut(user, ":")
+	if name == "" || hasGroup && groupName == "" {
+		return nil, fmt.Errorf("invalid user %s", user)
+	}
+	u := &User{Home: "/"}
+	found := false
+	// passwd的格式是name:password:uid:gid:gecos:home:shell
+	for _, fields := range readColonFile(passwd, 7) {
+		if fields[0] != name && fields[2] != name {
+			continue
+		}
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		u.Uid, u.Gid, u.Home = uid, gid, fields[5]
+		name, found = fields[0], true
+		break
+	}
+	if !found {
+		uid, err := strconv.Atoi(name)
+		if err != nil || uid < 0 {
+			return nil, fmt.Errorf("unable to find user %s", name)
+		}
+		u.Uid = uid
+	}
+
+	// group的格式是name:password:gid:user1,user2
+	groups := readColonFile(group, 4)
+	if hasGroup {
+		found = false
+		for _, fields := range groups {
+			if fields[0] != groupName && fields[2] != groupName {
+				continue
+			}
+			if gid, err := strconv.Atoi(fields[2]); err == nil {
+				u.Gid, found = gid, true
+				break
+			}
+		}
+		if !found {
+			gid, err := strconv.Atoi(groupName)
+			if err != nil || gid < 0 {
+				return nil, fmt.Errorf("unable to find group %s", groupName)
+			}
+			u.Gid = gid
+		}
+		return u, nil
+	}
+	for _, fields := range groups {
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != name {
+				continue
+			}
+			if gid, err := strconv.Atoi(fields[2]); err == nil && gid != u.Gid {
+				u.Groups = append(u.Groups, gid)
+			}
+			break
+		}
+	}
+	return u, nil
+}
+
+// 读取用冒号分隔的文件，跳过空行、注释和字段数不对的行
+func readColonFile(r io.Reader, n int) [][]string {
+	if r == nil {
+		return nil
+	}
+	var lines [][]string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Split(line, ":"); len(fields) == n {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
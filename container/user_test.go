@@ -0,0 +1,52 @@
+package container
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPasswd = `root:x:0:0:root:/root:/bin/sh
+# comment
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+app:x:1000:1000::/home/app:/bin/sh
+`
+
+const testGroup = `root:x:0:
+wheel:x:10:root,app
+staff:x:50:app
+app:x:1000:
+`
+
+func TestParseUser(t *testing.T) {
+	tests := []struct {
+		spec   string
+		expect User
+	}{
+		{"root", User{Uid: 0, Gid: 0, Groups: []int{10}, Home: "/root"}},
+		{"app", User{Uid: 1000, Gid: 1000, Groups: []int{10, 50}, Home: "/home/app"}},
+		{"1000", User{Uid: 1000, Gid: 1000, Groups: []int{10, 50}, Home: "/home/app"}},
+		{"app:staff", User{Uid: 1000, Gid: 50, Home: "/home/app"}},
+		{"nobody:20", User{Uid: 65534, Gid: 20, Home: "/nonexistent"}},
+		{"1234", User{Uid: 1234, Gid: 0, Home: "/"}},
+	}
+	for _, test := range tests {
+		u, err := parseUser(test.spec, strings.NewReader(testPasswd), strings.NewReader(testGroup))
+		if err != nil {
+			t.Errorf("parse user %s error %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(*u, test.expect) {
+			t.Errorf("user %s: expect %+v, got %+v", test.spec, test.expect, *u)
+		}
+	}
+	for _, spec := range []string{"", "nosuch", "app:nosuch", "app:", ":0", "-1"} {
+		if _, err := parseUser(spec, strings.NewReader(testPasswd), strings.NewReader(testGroup)); err == nil {
+			t.Errorf("expect error for %q", spec)
+		}
+	}
+	// 容器中没有passwd和group时只能使用数字
+	if u, err := parseUser("5:6", nil, nil); err != nil || u.Uid != 5 || u.Gid != 6 {
+		t.Errorf("unexpected %+v %v", u, err)
+	}
+}
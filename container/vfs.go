@@ -0,0 +1,95 @@
+package container
+
+import (
+	"example/mydocker/archive"
+	"example/mydocker/paths"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// vfs驱动，把镜像的各层依次拷贝到读写层中得到完整的rootfs，不依赖overlay，
+// 在不能使用overlay的环境(比如overlay上再挂overlay)中也能运行，代价是每个容器都有一份镜像的拷贝
+// 容器的修改需要和镜像各层对比得到
+type VfsDriver struct {
+}
+
+func (d *VfsDriver) Name() string {
+	return "vfs"
+}
+
+func (d *VfsDriver) Create(containerName string, imageID string, storageOpts *StorageOptions) error {
+	if err := CreatWriteLayer(containerName, storageOpts); err != nil {
+		return err
+	}
+	lowerURLs, err := ImageLowerDirs(imageID)
+	if err != nil {
+		return fmt.Errorf("get image %s layers error %v", imageID, err)
+	}
+	writeURL := paths.WriteLayerUrl(containerName)
+	// 从最底层开始，把每一层打包后解压到读写层上，解压时删除被whiteout的文件
+	for i := len(lowerURLs) - 1; i >= 0; i-- {
+		if err := copyLayer(lowerURLs[i], writeURL); err != nil {
+			return fmt.Errorf("copy layer %s error %v", lowerURLs[i], err)
+		}
+	}
+	return nil
+}
+
+func copyLayer(layerURL string, dest string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Tar(layerURL, pw, &archive.Options{OverlayWhiteout: true}))
+	}()
+	err := archive.Untar(pr, dest, nil)
+	pr.CloseWithError(err)
+	return err
+}
+
+// 读写层就是完整的rootfs，bind挂载到MntUrl
+func (d *VfsDriver) Mount(containerName string, imageID string) error {
+	mntURL := paths.MntUrl(containerName)
+	if err := os.MkdirAll(mntURL, 0777); err != nil {
+		return fmt.Errorf("mkdir mntURL %s error %v", mntURL, err)
+	}
+	writeURL := paths.WriteLayerUrl(containerName)
+	if err := unix.Mount(writeURL, mntURL, "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s to %s error %v", writeURL, mntURL, err)
+	}
+	return nil
+}
+
+func (d *VfsDriver) Unmount(containerName string) error {
+	return DeleteMountPoint(containerName)
+}
+
+func (d *VfsDriver) Remove(containerName string) error {
+	return DeleteWriteLayer(containerName)
+}
+
+func (d *VfsDriver) Diff(containerName string, imageID string) (io.ReadCloser, error) {
+	changes, err := d.Changes(containerName, imageID)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.ExportChanges(paths.WriteLayerUrl(containerName), changes, pw))
+	}()
+	return pr, nil
+}
+
+func (d *VfsDriver) Changes(containerName string, imageID string) ([]archive.Change, error) {
+	lowerURLs, err := ImageLowerDirs(imageID)
+	if err != nil {
+		return nil, fmt.Errorf("get image %s layers error %v", imageID, err)
+	}
+	return archive.Changes(lowerURLs, paths.WriteLayerUrl(containerName))
+}
+
+// rootfs是合并后的内容，解压时直接删除被.wh.标记的文件
+func (d *VfsDriver) ApplyDiff(containerName string, diff io.Reader) error {
+	return archive.Untar(diff, paths.WriteLayerUrl(containerName), nil)
+}
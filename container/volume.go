@@ -1,148 +1,194 @@
 package container
 
 import (
+	"bufio"
+	"example/mydocker/paths"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
-// 为每个容器创建一个workspace
-func NewWorkSpace(volume string, containerName string, imageName string) {
-	CreatReadOnlyLayer(imageName)
-	CreatWriteLayer(containerName)
-	CreatMountPoint(containerName, imageName, volume)
-}
-
-func CreatReadOnlyLayer(imageName string) {
-	imageURL := RootUrl + "/" + imageName + "/"
-	imageTarURL := RootUrl + "/" + imageName + ".tar"
-	// exist, err := PathExists(busyboxURL)
-	_, err := os.Stat(imageURL)
+// 用配置的存储驱动为每个容器创建一个workspace，-v等挂载由init进程在容器内完成
+// 创建失败时清理掉已经创建的部分，不会留下挂载点；storageOpts为nil时不限制读写层的大小
+func NewWorkSpace(containerName string, imageID string, storageOpts *StorageOptions) error {
+	driver, err := GetStorageDriver(paths.StorageDriver())
 	if err != nil {
-		log.Infof("Fail to judge whether dir %s exists. %v", imageURL, err)
+		return err
 	}
-
-	if os.IsNotExist(err) {
-		fmt.Println(imageURL, " isn't exist.")
-		if err := os.MkdirAll(imageURL, 0777); err != nil {
-			log.Errorf("mkdir imageURL %s error. %v", imageURL, err)
+	if err := driver.Create(containerName, imageID, storageOpts); err != nil {
+		if err := driver.Remove(containerName); err != nil {
+			log.Errorf("clean write layer of %s error %v", containerName, err)
 		}
-		if _, err := exec.Command("tar", "-xvf", imageTarURL, "-C", imageURL).CombinedOutput(); err != nil {
-			log.Errorf("untar imageTarURL %s error. %v", imageTarURL, err)
+		return err
+	}
+	if err := driver.Mount(containerName, imageID); err != nil {
+		if err := DeleteWorkSpace(containerName); err != nil {
+			log.Errorf("clean workspace of %s error %v", containerName, err)
 		}
+		return err
 	}
+	return nil
 }
 
-func CreatWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl,containerName)
+func CreatWriteLayer(containerName string, storageOpts *StorageOptions) error {
+	writeURL := paths.WriteLayerUrl(containerName)
 	if err := os.MkdirAll(writeURL, 0777); err != nil {
-		log.Errorf("mkdir writeURL %s error. %v", writeURL, err)
+		return fmt.Errorf("mkdir writeURL %s error %v", writeURL, err)
 	}
-	workURL := fmt.Sprintf(WorkLayerUrl,containerName)
+	workURL := paths.WorkLayerUrl(containerName)
 	if err := os.MkdirAll(workURL, 0777); err != nil {
-		log.Errorf("mkdir workURL %s error. %v", workURL, err)
+		return fmt.Errorf("mkdir workURL %s error %v", workURL, err)
 	}
+	if storageOpts != nil && storageOpts.Size > 0 {
+		return setUpStorageQuota(containerName, storageOpts.Size)
+	}
+	return nil
 }
 
-func CreatMountPoint(containerName string, imageName string, volume string) {
-	mntURL := fmt.Sprintf(MntUrl,containerName)
-	// fmt.Println("创建mnt目录:", mntURL)
+func CreatMountPoint(containerName string, imageID string) error {
+	mntURL := paths.MntUrl(containerName)
 	if err := os.MkdirAll(mntURL, 0777); err != nil {
-		log.Errorf("mkdir mntURL %s error. %v", mntURL, err)
-	}
-	imageURL := RootUrl + "/" + imageName + "/"
-	writeURL := fmt.Sprintf(WriteLayerUrl,containerName)
-	workURL := fmt.Sprintf(WorkLayerUrl,containerName)
-	dirs := "lowerdir=" + imageURL + ",upperdir=" + writeURL + ",workdir=" + workURL
-	cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", dirs, mntURL)
-	// fmt.Println("dirs:", dirs)
-
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Errorf("mount %v", err)
+		return fmt.Errorf("mkdir mntURL %s error %v", mntURL, err)
 	}
-
-	if volume != "" {
-		volumeURLs := strings.Split(volume, ":")
-		if len(volumeURLs) == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			MountVolume(volumeURLs,containerName)
-			log.Infof("create volume mountpoint:", strings.Join(volumeURLs, " "))
-		} else {
-			log.Infof("Volume parameter input is not correct")
-		}
+	// 镜像的各层作为只读层
+	lowerURLs, err := ImageLowerDirs(imageID)
+	if err != nil {
+		return fmt.Errorf("get image %s layers error %v", imageID, err)
 	}
-}
-
-func MountVolume(volumeURLs []string,containerName string) {
-	parentURL := volumeURLs[0]
-	if err := os.MkdirAll(parentURL, 0777); err != nil {
-		log.Errorf("mkdir parentURL %s error. %v", parentURL, err)
-	}
-	containerURL := volumeURLs[1]
-	mntURL := fmt.Sprintf(MntUrl,containerName)
-	containerVolumeURL := mntURL+ "/" + containerURL
-	fmt.Println("parentURL:", parentURL)
-	fmt.Println("containerVolumeURL:", containerVolumeURL)
-	if err := os.MkdirAll(containerVolumeURL, 0777); err != nil {
-		log.Errorf("mkdir containerVolumeURL %s error. %v", containerVolumeURL, err)
-	}
-	// 这里不确定是否是正确的写法，但是可以满足要求
-	// dirs := "lowerdir=" + parentURL + ",upperdir=" + rootURL + "writeLayer" + ",workdir=" + rootURL + "work"
-	// dirs := "lowerdir=" + parentURL + ",upperdir=" + parentURL + ",workdir=" + rootURL + "work"
-	// cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", dirs, containerVolumeURL)
-	cmd := exec.Command("mount", "--bind", parentURL, containerVolumeURL)
-	// fmt.Println("volume dirs:", dirs)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Errorf("mount volume %v", err)
+	writeURL, workURL := overlayDirs(containerName)
+	dirs := "lowerdir=" + strings.Join(lowerURLs, ":") + ",upperdir=" + writeURL + ",workdir=" + workURL
+	if err := unix.Mount("overlay", mntURL, "overlay", 0, dirs); err != nil {
+		return fmt.Errorf("mount overlay on %s error %v", mntURL, err)
 	}
+	return nil
 }
 
-func DeleteWorkSpace(volume string,containerName string) {
-	DeleteMountPoint(containerName, volume)
-	DeleteWriteLayer(containerName)
+// 删除容器的workspace，可以重复调用；rootfs仍被占用时返回错误并保留读写层，稍后可以重试
+// 各个存储驱动的workspace由同样的挂载点和目录组成，不需要知道创建它的驱动
+func DeleteWorkSpace(containerName string) error {
+	if err := DeleteMountPoint(containerName); err != nil {
+		return err
+	}
+	return DeleteWriteLayer(containerName)
 }
 
-func DeleteWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl,containerName)
+func DeleteWriteLayer(containerName string) error {
+	if err := removeStorageQuota(containerName); err != nil {
+		return err
+	}
+	writeURL := paths.WriteLayerUrl(containerName)
 	if err := os.RemoveAll(writeURL); err != nil {
-		log.Errorf("remove writeURL %s error. %v", writeURL, err)
+		return fmt.Errorf("remove writeURL %s error %v", writeURL, err)
 	}
-	workURL := fmt.Sprintf(WorkLayerUrl,containerName)
+	workURL := paths.WorkLayerUrl(containerName)
 	if err := os.RemoveAll(workURL); err != nil {
-		log.Errorf("remove workURL %s error. %v", workURL, err)
+		return fmt.Errorf("remove workURL %s error %v", workURL, err)
 	}
+	return nil
 }
 
-func DeleteMountPoint(containerName string, volume string) {
-	mntURL := fmt.Sprintf(MntUrl,containerName)
-	if volume != "" {
-		volumeURLs := strings.Split(volume, ":")
-		if len(volumeURLs) == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			cmd := exec.Command("umount", mntURL+volumeURLs[1])
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				log.Errorf("umount volume failed %v", err)
-			}
-			log.Infof("Delete Volume Mount Point: ", strings.Join(volumeURLs, " "))
+// 容器内的挂载都在容器的mount namespace中，容器退出时随namespace一起消失，这里只需要卸载rootfs
+func DeleteMountPoint(containerName string) error {
+	mntURL := paths.MntUrl(containerName)
+	mounted, err := isMountPoint(mntURL)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		if err := unix.Unmount(mntURL, 0); err == unix.EBUSY {
+			return fmt.Errorf("rootfs %s is busy, stop the processes using it and retry", mntURL)
+		} else if err != nil {
+			return fmt.Errorf("umount %s error %v", mntURL, err)
 		}
 	}
+	// 只删除空的挂载点目录，卸载失败时不能删除其中的内容，否则会删掉镜像层或者卷里的数据
+	if err := os.Remove(mntURL); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove mntURL %s error %v", mntURL, err)
+	}
+	return nil
+}
 
-	cmd := exec.Command("umount", mntURL)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Errorf("umount %v", err)
+// 确保容器的rootfs已经挂载，停止的容器在宿主机重启之后rootfs就没有挂载了
+// 返回rootfs是不是这次挂载的，用完之后需要调用driver.Unmount卸载
+func MountRootfs(containerName string, imageID string, driver StorageDriver) (bool, error) {
+	mounted, err := isMountPoint(paths.MntUrl(containerName))
+	if err != nil || mounted {
+		return false, err
+	}
+	if _, err := os.Stat(paths.WriteLayerUrl(containerName)); err != nil {
+		return false, fmt.Errorf("workspace of container %s not found", containerName)
 	}
+	if err := driver.Mount(containerName, imageID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	if err := os.RemoveAll(mntURL); err != nil {
-		log.Errorf("remove mntURL %s error. %v", mntURL, err)
+// 根据/proc/self/mountinfo判断path是不是一个挂载点，path不存在时返回false
+func isMountPoint(path string) (bool, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
 	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) > 4 && unescapeMountPath(fields[4]) == path {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
 
+// 根据/proc/self/mountinfo列出path本身以及它下面的所有挂载点，按挂载的先后排列
+func subMounts(path string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mountPoints []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) <= 4 {
+			continue
+		}
+		mountPoint := unescapeMountPath(fields[4])
+		if mountPoint == path || strings.HasPrefix(mountPoint, strings.TrimSuffix(path, "/")+"/") {
+			mountPoints = append(mountPoints, mountPoint)
+		}
+	}
+	return mountPoints, scanner.Err()
+}
+
+// mountinfo中路径里的空格、制表符、换行和反斜杠被转义成\040这样的八进制形式
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
 }
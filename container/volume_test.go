@@ -0,0 +1,28 @@
+package container
+
+import "testing"
+
+func TestUnescapeMountPath(t *testing.T) {
+	for s, expected := range map[string]string{
+		"/var/lib/mydocker/mnt/web": "/var/lib/mydocker/mnt/web",
+		`/mnt/my\040dir`:            "/mnt/my dir",
+		`/mnt/a\134b`:               `/mnt/a\b`,
+		`/mnt/bad\9`:                `/mnt/bad\9`,
+	} {
+		if path := unescapeMountPath(s); path != expected {
+			t.Errorf("unescape %s: expect %s, got %s", s, expected, path)
+		}
+	}
+}
+
+func TestIsMountPoint(t *testing.T) {
+	if mounted, err := isMountPoint("/proc"); err != nil || !mounted {
+		t.Errorf("expect /proc to be a mount point, got %v %v", mounted, err)
+	}
+	if mounted, err := isMountPoint(t.TempDir()); err != nil || mounted {
+		t.Errorf("expect temp dir not to be a mount point, got %v %v", mounted, err)
+	}
+	if mounted, err := isMountPoint("/nonexistent/path"); err != nil || mounted {
+		t.Errorf("expect missing path not to be a mount point, got %v %v", mounted, err)
+	}
+}
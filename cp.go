@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"example/mydocker/archive"
+	"example/mydocker/container"
+	"example/mydocker/paths"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 在宿主机和容器之间拷贝文件，容器中的路径写成 容器名:路径，宿主机一端为-时通过标准输入输出传递tar流
+// 目标是已经存在的目录时拷贝到目录下，否则拷贝成目标本身；源路径以/.结尾时只拷贝目录中的内容
+// followLink为true时源路径是符号链接就拷贝链接指向的文件，archive为true时保留文件的属主
+func CopyFiles(src string, dest string, followLink bool, archiveMode bool) error {
+	srcContainer, srcPath := splitCopyPath(src)
+	destContainer, destPath := splitCopyPath(dest)
+	if srcContainer != "" && destContainer != "" {
+		return fmt.Errorf("copying between containers is not supported")
+	}
+	if srcContainer == "" && destContainer == "" {
+		return fmt.Errorf("must specify at least one container source")
+	}
+	// 默认拷贝出的文件属于执行拷贝的用户，即root
+	opts := &archive.Options{NoLchown: !archiveMode}
+
+	if srcContainer != "" {
+		rootfs, err := openRootfs(srcContainer)
+		if err != nil {
+			return err
+		}
+		defer rootfs.close()
+		srcRoot, err := rootfs.resolve(srcPath, followLink)
+		if err != nil {
+			return err
+		}
+		if destPath == "-" {
+			// tar流占用了标准输出，日志改为输出到标准错误，避免混进tar包
+			log.SetOutput(os.Stderr)
+			return tarInRoot(srcRoot, copyName(srcPath), os.Stdout)
+		}
+		destURL, err := filepath.Abs(destPath)
+		if err != nil {
+			return err
+		}
+		return copyPath(srcRoot, srcPath, hostPath(destURL), destPath, opts)
+	}
+
+	rootfs, err := openRootfs(destContainer)
+	if err != nil {
+		return err
+	}
+	defer rootfs.close()
+	destRoot, err := rootfs.resolve(destPath, true)
+	if err != nil {
+		return err
+	}
+	if destRoot.readOnly {
+		return fmt.Errorf("destination %s is on a read-only filesystem", destPath)
+	}
+	if srcPath == "-" {
+		if fi, err := os.Stat(destRoot.hostPath()); err != nil || !fi.IsDir() {
+			return fmt.Errorf("destination %s must be an existing directory", destPath)
+		}
+		return untarInRoot(destRoot, os.Stdin, opts)
+	}
+	srcURL, err := filepath.Abs(srcPath)
+	if err != nil {
+		return err
+	}
+	if followLink {
+		if srcURL, err = filepath.EvalSymlinks(srcURL); err != nil {
+			return err
+		}
+	}
+	return copyPath(hostPath(srcURL), srcPath, destRoot, destPath, opts)
+}
+
+// 拆分 容器名:路径 格式的参数，以/或者.开头的是宿主机上的路径，路径中可以有冒号
+func splitCopyPath(arg string) (string, string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	i := strings.Index(arg, ":")
+	if i <= 0 {
+		return "", arg
+	}
+	return arg[:i], arg[i+1:]
+}
+
+// 打包时源路径使用的名字，只拷贝目录内容时是.
+func copyName(path string) string {
+	if path == "/" || strings.HasSuffix(path, "/.") || path == "." {
+		return "."
+	}
+	return filepath.Base(path)
+}
+
+// 把src拷贝到dest，srcPath和destPath是用户给出的路径，用来判断是否只拷贝目录内容以及目标是否必须是目录
+func copyPath(src *rootPath, srcPath string, dest *rootPath, destPath string, opts *archive.Options) error {
+	srcInfo, err := os.Lstat(src.hostPath())
+	if err != nil {
+		return fmt.Errorf("source %s error %v", srcPath, err)
+	}
+	name := copyName(srcPath)
+	parent := *dest
+	destInfo, err := os.Stat(dest.hostPath())
+	switch {
+	case err == nil && destInfo.IsDir():
+		// 拷贝到目录下，只拷贝内容时直接解压到目录中
+	case err == nil:
+		if srcInfo.IsDir() {
+			return fmt.Errorf("cannot copy a directory to file %s", destPath)
+		}
+		parent.path, name = filepath.Dir(dest.path), filepath.Base(dest.path)
+	case os.IsNotExist(err):
+		if strings.HasSuffix(destPath, "/") && !srcInfo.IsDir() {
+			return fmt.Errorf("destination directory %s does not exist", destPath)
+		}
+		parent.path, name = filepath.Dir(dest.path), filepath.Base(dest.path)
+		if fi, err := os.Stat(parent.hostPath()); err != nil || !fi.IsDir() {
+			return fmt.Errorf("parent directory of %s does not exist", destPath)
+		}
+	default:
+		return fmt.Errorf("destination %s error %v", destPath, err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarInRoot(src, name, pw))
+	}()
+	err = untarInRoot(&parent, pr, opts)
+	pr.CloseWithError(err)
+	return err
+}
+
+// 拷贝的一端：在root中的路径path，容器中的路径root是rootfs或者挂载的源目录，宿主机上的路径root是/
+// 前面对路径的解析只用来做判断，真正读写时chroot到root中再访问path，
+// 即使容器中的进程在这之间把路径中的目录换成符号链接，也只会解析到root之内
+type rootPath struct {
+	root     string
+	path     string
+	readOnly bool
+}
+
+func hostPath(path string) *rootPath {
+	return &rootPath{root: "/", path: path}
+}
+
+func (p *rootPath) hostPath() string {
+	return filepath.Join(p.root, p.path)
+}
+
+// 把p打包写入w，包中的路径以name开头
+func tarInRoot(p *rootPath, name string, w io.Writer) error {
+	if p.root == "/" {
+		return archive.TarPath(p.path, name, w, nil)
+	}
+	cmd := chrootArchiveCmd(p.root, "--name", name, p.path)
+	cmd.Stdout = w
+	return runChrootArchiveCmd(cmd)
+}
+
+// 把r中的tar包解压到目录p
+func untarInRoot(p *rootPath, r io.Reader, opts *archive.Options) error {
+	if p.root == "/" {
+		return archive.Untar(r, p.path, opts)
+	}
+	args := []string{"--untar"}
+	if opts.NoLchown {
+		args = append(args, "--no-lchown")
+	}
+	cmd := chrootArchiveCmd(p.root, append(args, p.path)...)
+	cmd.Stdin = r
+	return runChrootArchiveCmd(cmd)
+}
+
+func chrootArchiveCmd(root string, args ...string) *exec.Cmd {
+	return exec.Command("/proc/self/exe", append([]string{chrootArchiveCommand.Name, "--root", root}, args...)...)
+}
+
+// 子进程出错时把错误写到标准错误后退出，见RunChrootArchive
+func runChrootArchiveCmd(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
+
+// 在chroot-archive子进程中执行，chroot到root后打包path写到标准输出，或者把标准输入的tar包解压到path
+func RunChrootArchive(root string, path string, name string, untar bool, noLchown bool) error {
+	if err := syscall.Chroot(root); err != nil {
+		return fmt.Errorf("chroot %s error %v", root, err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return fmt.Errorf("chdir error %v", err)
+	}
+	if untar {
+		return archive.Untar(os.Stdin, path, &archive.Options{NoLchown: noLchown})
+	}
+	return archive.TarPath(path, name, os.Stdout, nil)
+}
+
+// 容器合并后的rootfs，停止的容器的rootfs没有挂载时临时挂载上
+type containerRootfs struct {
+	info    *container.ContainerInfo
+	driver  container.StorageDriver
+	mntURL  string
+	mounted bool
+}
+
+func openRootfs(containerName string) (*containerRootfs, error) {
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	driver, err := containerStorageDriver(info)
+	if err != nil {
+		return nil, err
+	}
+	mounted, err := container.MountRootfs(containerName, info.Image, driver)
+	if err != nil {
+		return nil, fmt.Errorf("mount rootfs of container %s error %v", containerName, err)
+	}
+	return &containerRootfs{info: info, driver: driver, mntURL: paths.MntUrl(containerName), mounted: mounted}, nil
+}
+
+func (r *containerRootfs) close() {
+	if !r.mounted {
+		return
+	}
+	if err := r.driver.Unmount(r.info.Name); err != nil {
+		log.Errorf("umount rootfs of container %s error %v", r.info.Name, err)
+	}
+}
+
+// 把容器中的路径解析到rootfs或者挂载的源目录中，符号链接都在其中解析，不会指到宿主机上
+// 路径在-v挂载的目录或者卷中时解析到挂载的源目录，tmpfs挂载只在容器中可见，不能拷贝
+// followLink为false时最后一个分量是符号链接也不解析，拷贝链接本身
+func (r *containerRootfs) resolve(path string, followLink bool) (*rootPath, error) {
+	path = filepath.Clean("/" + path)
+	rel, err := relJoin(r.mntURL, path, followLink)
+	if err != nil {
+		return nil, err
+	}
+
+	var mount *container.Mount
+	for i := range r.info.Mounts {
+		m := &r.info.Mounts[i]
+		if rel != m.Destination && !strings.HasPrefix(rel, m.Destination+"/") {
+			continue
+		}
+		if mount == nil || len(m.Destination) > len(mount.Destination) {
+			mount = m
+		}
+	}
+	if mount == nil {
+		return &rootPath{root: r.mntURL, path: rel, readOnly: r.info.ReadOnlyRoot}, nil
+	}
+	if mount.Type == container.MountTypeTmpfs {
+		return nil, fmt.Errorf("%s is on tmpfs mount %s, which is only visible inside the container", path, mount.Destination)
+	}
+	if fi, err := os.Stat(mount.Source); err == nil && !fi.IsDir() {
+		// 挂载的是单个文件，以它所在的目录为root
+		return &rootPath{root: filepath.Dir(mount.Source), path: "/" + filepath.Base(mount.Source), readOnly: mount.ReadOnly}, nil
+	}
+	rel, err = relJoin(mount.Source, strings.TrimPrefix(rel, mount.Destination), followLink)
+	if err != nil {
+		return nil, err
+	}
+	return &rootPath{root: mount.Source, path: rel, readOnly: mount.ReadOnly}, nil
+}
+
+// 在root中解析path，返回解析后相对root的绝对路径
+func relJoin(root string, path string, followLink bool) (string, error) {
+	resolved, err := secureJoin(root, path, followLink)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join("/", rel), nil
+}
+
+func secureJoin(root string, path string, followLink bool) (string, error) {
+	if followLink || path == "/" || path == "" {
+		return archive.SecureJoin(root, path)
+	}
+	parent, err := archive.SecureJoin(root, filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(path)), nil
+}
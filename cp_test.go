@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"example/mydocker/archive"
+	"example/mydocker/container"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestMain(m *testing.M) {
+	// cp的chroot-archive子进程重新执行的是测试程序，在这里执行子进程的命令
+	if len(os.Args) > 1 && os.Args[1] == chrootArchiveCommand.Name {
+		app := cli.NewApp()
+		app.Commands = []cli.Command{chrootArchiveCommand}
+		app.Run(os.Args)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestSplitCopyPath(t *testing.T) {
+	tests := []struct {
+		arg       string
+		container string
+		path      string
+	}{
+		{"web:/etc/hosts", "web", "/etc/hosts"},
+		{"web:a:b", "web", "a:b"},
+		{"web:", "web", ""},
+		{"/tmp/a:b", "", "/tmp/a:b"},
+		{"./web:a", "", "./web:a"},
+		{":a", "", ":a"},
+		{"file", "", "file"},
+		{"-", "", "-"},
+	}
+	for _, test := range tests {
+		c, p := splitCopyPath(test.arg)
+		if c != test.container || p != test.path {
+			t.Errorf("split %s: expect %q %q, got %q %q", test.arg, test.container, test.path, c, p)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	mnt := filepath.Join(tmp, "mnt")
+	data := filepath.Join(tmp, "data")
+	conf := filepath.Join(tmp, "app.conf")
+	for _, dir := range []string{filepath.Join(mnt, "etc"), filepath.Join(mnt, "data"), data} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(conf, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		filepath.Join(mnt, "link"):   "/etc",
+		filepath.Join(mnt, "up"):     "../../..",
+		filepath.Join(mnt, "todata"): "/data",
+		filepath.Join(data, "abs"):   "/etc",
+		filepath.Join(data, "out"):   "../../..",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rootfs := &containerRootfs{
+		info: &container.ContainerInfo{
+			Mounts: []container.Mount{
+				{Type: container.MountTypeBind, Source: data, Destination: "/data"},
+				{Type: container.MountTypeBind, Source: conf, Destination: "/conf/app.conf", ReadOnly: true},
+				{Type: container.MountTypeTmpfs, Destination: "/run"},
+			},
+			ReadOnlyRoot: true,
+		},
+		mntURL: mnt,
+	}
+
+	tests := []struct {
+		path       string
+		followLink bool
+		expect     rootPath
+	}{
+		{"/etc/passwd", true, rootPath{mnt, "/etc/passwd", true}},
+		{"link/passwd", true, rootPath{mnt, "/etc/passwd", true}},
+		{"../../up/x", true, rootPath{mnt, "/x", true}},
+		{"/link", false, rootPath{mnt, "/link", true}},
+		{"/link", true, rootPath{mnt, "/etc", true}},
+		{"/data", true, rootPath{data, "/", false}},
+		{"/todata/f", true, rootPath{data, "/f", false}},
+		{"/data/abs/x", true, rootPath{data, "/etc/x", false}},
+		{"/data/out/x", true, rootPath{data, "/x", false}},
+		{"/data/abs", false, rootPath{data, "/abs", false}},
+		{"/conf/app.conf", true, rootPath{tmp, "/app.conf", true}},
+	}
+	for _, test := range tests {
+		p, err := rootfs.resolve(test.path, test.followLink)
+		if err != nil {
+			t.Errorf("resolve %s error %v", test.path, err)
+			continue
+		}
+		if *p != test.expect {
+			t.Errorf("resolve %s: expect %+v, got %+v", test.path, test.expect, *p)
+		}
+	}
+	if _, err := rootfs.resolve("/run/x", true); err == nil {
+		t.Errorf("expect error for path on tmpfs")
+	}
+}
+
+// 解析之后路径中的目录被换成指向宿主机的符号链接时，chroot之后的读写仍然在root之内
+func TestCopySymlinkInRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chroot requires root")
+	}
+	tmp, err := ioutil.TempDir("", "cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	// 符号链接在root之内指向的目录也存在，chroot之后拷贝到这里
+	for _, dir := range []string{filepath.Join(root, outside), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret := filepath.Join(outside, "secret")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "a.txt")
+	if err := ioutil.WriteFile(src, []byte("copy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &archive.Options{NoLchown: true}
+	if err := copyPath(hostPath(src), src, &rootPath{root: root, path: "/dir"}, "/dir", opts); err != nil {
+		t.Fatalf("copy into root error %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("file created outside root: %v", err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(root, outside, "a.txt")); err != nil || string(content) != "copy" {
+		t.Errorf("expect file in root at %s, got %q %v", outside, content, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tarInRoot(&rootPath{root: root, path: "/file"}, "file", &buf); err != nil {
+		t.Fatalf("tar in root error %v", err)
+	}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			t.Errorf("expect %s to be packed as a symlink, got type %c", hdr.Name, hdr.Typeflag)
+		}
+	}
+	if err := tarInRoot(&rootPath{root: root, path: "/dir/secret"}, "secret", ioutil.Discard); err == nil {
+		t.Errorf("expect error reading through a symlink pointing outside root")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"example/mydocker/archive"
+	"fmt"
+)
+
+// 列出容器相对于镜像的修改，A是新增、C是修改、D是删除；format为json时输出JSON数组，
+// Kind为0、1、2分别表示修改、新增和删除
+func DiffContainer(containerName string, format string) error {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if containerInfo.Image == "" {
+		return fmt.Errorf("container %s is not created from an image", containerName)
+	}
+	driver, err := containerStorageDriver(containerInfo)
+	if err != nil {
+		return err
+	}
+	changes, err := driver.Changes(containerName, containerInfo.Image)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "":
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	case "json":
+		if changes == nil {
+			changes = []archive.Change{}
+		}
+		jsonBytes, err := json.MarshalIndent(changes, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+	default:
+		return fmt.Errorf("unknown format %s, only json is supported", format)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package dockerfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 支持的指令，只实现了Dockerfile的一个子集
+var supportedInstructions = map[string]bool{
+	"FROM":       true,
+	"RUN":        true,
+	"COPY":       true,
+	"ADD":        true,
+	"ENV":        true,
+	"WORKDIR":    true,
+	"CMD":        true,
+	"ENTRYPOINT": true,
+	"LABEL":      true,
+}
+
+type Instruction struct {
+	// 大写的指令名
+	Cmd string
+	// 指令后面的原始内容，已经去掉了续行符
+	Raw string
+	// 参数，JSON数组格式时为数组中的元素，否则按空白分隔，支持引号
+	Args []string
+	// 参数是否是JSON数组格式，如 CMD ["top", "-b"]
+	JSON bool
+	// 指令所在的行号，用于报错
+	Line int
+}
+
+func (i *Instruction) String() string {
+	return i.Cmd + " " + i.Raw
+}
+
+// 解析Dockerfile，#开头的行是注释，行尾的\表示下一行是续行
+func Parse(r io.Reader) ([]*Instruction, error) {
+	var instructions []*Instruction
+	scanner := bufio.NewScanner(r)
+	lineNo, startLine := 0, 0
+	var current string
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") || (line == "" && current == "") {
+			continue
+		}
+		if current == "" {
+			startLine = lineNo
+		}
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		current += line
+		instruction, err := parseLine(current, startLine)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != "" {
+		instruction, err := parseLine(current, startLine)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("dockerfile is empty")
+	}
+	if instructions[0].Cmd != "FROM" {
+		return nil, fmt.Errorf("line %d: dockerfile must start with FROM", instructions[0].Line)
+	}
+	return instructions, nil
+}
+
+func parseLine(line string, lineNo int) (*Instruction, error) {
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	instruction := &Instruction{
+		Cmd:  strings.ToUpper(fields[0]),
+		Line: lineNo,
+	}
+	if !supportedInstructions[instruction.Cmd] {
+		return nil, fmt.Errorf("line %d: unsupported instruction %s", lineNo, fields[0])
+	}
+	if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+		return nil, fmt.Errorf("line %d: %s requires at least one argument", lineNo, instruction.Cmd)
+	}
+	instruction.Raw = strings.TrimSpace(fields[1])
+
+	if strings.HasPrefix(instruction.Raw, "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(instruction.Raw), &args); err == nil {
+			instruction.Args = args
+			instruction.JSON = true
+			return instruction, nil
+		}
+		// 不是合法的JSON数组时按普通字符串处理，和docker的行为一致
+	}
+	args, err := SplitWords(instruction.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", lineNo, err)
+	}
+	instruction.Args = args
+	return instruction, nil
+}
+
+// 按空白分隔字符串，单引号和双引号中的空白不分隔，\转义下一个字符
+func SplitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// 解析ENV和LABEL的参数，支持 KEY=VALUE KEY2="VALUE 2" 和 KEY VALUE 两种格式
+func ParseKeyValues(instruction *Instruction) ([][2]string, error) {
+	var pairs [][2]string
+	if !strings.Contains(instruction.Args[0], "=") {
+		if len(instruction.Args) < 2 {
+			return nil, fmt.Errorf("line %d: %s requires a value for %s", instruction.Line, instruction.Cmd, instruction.Args[0])
+		}
+		fields := strings.SplitN(instruction.Raw, " ", 2)
+		return append(pairs, [2]string{fields[0], strings.TrimSpace(fields[1])}), nil
+	}
+	for _, arg := range instruction.Args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("line %d: invalid %s argument %s", instruction.Line, instruction.Cmd, arg)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,50 @@
+package dockerfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	content := `# comment
+FROM busybox
+ENV GREETING="hello world" NAME=mydocker
+RUN echo $GREETING && \
+    touch /tmp/a
+CMD ["top", "-b"]
+`
+	instructions, err := Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(instructions) != 4 {
+		t.Fatalf("expect 4 instructions, got %d", len(instructions))
+	}
+	if run := instructions[2]; run.Cmd != "RUN" || run.Raw != "echo $GREETING &&  touch /tmp/a" || run.Line != 4 {
+		t.Errorf("unexpected RUN instruction: %+v", run)
+	}
+	if cmd := instructions[3]; !cmd.JSON || !reflect.DeepEqual(cmd.Args, []string{"top", "-b"}) {
+		t.Errorf("unexpected CMD instruction: %+v", cmd)
+	}
+	pairs, err := ParseKeyValues(instructions[1])
+	if err != nil {
+		t.Fatalf("parse env error: %v", err)
+	}
+	expected := [][2]string{{"GREETING", "hello world"}, {"NAME", "mydocker"}}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("unexpected env %v", pairs)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, content := range []string{
+		"RUN echo before from",
+		"FROM busybox\nEXPOSE 80",
+		"FROM busybox\nRUN",
+	} {
+		if _, err := Parse(strings.NewReader(content)); err == nil {
+			t.Errorf("expect error for %q", content)
+		}
+	}
+}
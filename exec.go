@@ -1,51 +1,180 @@
 package main
 
 import (
+	"bytes"
+	"example/mydocker/cgroups"
+	"example/mydocker/container"
 	_ "example/mydocker/nsenter"
+	"example/mydocker/term"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// 设置了这个环境变量的mydocker exec进程启动时由nsenter进入对应容器的namespace
 const ENV_EXEC_PID = "mydocker_pid"
-const ENV_EXEC_CMD = "mydocker_cmd"
 
-func ExecContainer(containerName string, commandArray []string) {
+type execOptions struct {
+	tty    bool
+	detach bool
+	// -e设置的环境变量，覆盖容器中同名的环境变量
+	env        []string
+	user       string
+	workingDir string
+}
+
+// 在运行中的容器里执行命令，返回命令的退出码；-d时命令启动后就返回0
+func ExecContainer(containerName string, args []string, opts *execOptions) (int, error) {
 	containerInfo, err := getContainerInfo(containerName)
-	pid := containerInfo.Pid
 	if err != nil {
-		log.Errorf("getContainerPidByName:%s error %v", containerName, err)
-		return
+		return 0, fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if !containerAlive(containerInfo) {
+		return 0, fmt.Errorf("container %s is not running", containerName)
+	}
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return 0, fmt.Errorf("invalid container pid %s", containerInfo.Pid)
+	}
+	environ, err := getEnvsByPid(containerInfo.Pid)
+	if err != nil {
+		return 0, err
+	}
+	config := &container.ExecConfig{
+		Args:       args,
+		Env:        mergeEnv(environ, opts.env),
+		User:       opts.user,
+		WorkingDir: opts.workingDir,
+		Tty:        opts.tty,
 	}
-	oneCommand := strings.Join(commandArray, " ")
-	log.Infof("pid:%s cmd:%s", pid, oneCommand)
+	// 没有-w时使用镜像的工作目录
+	if config.WorkingDir == "" {
+		if imageConfig, err := container.ReadImageConfig(containerInfo.Image); err == nil {
+			config.WorkingDir = imageConfig.WorkingDir
+		}
+	}
+	log.Infof("pid:%s cmd:%s", containerInfo.Pid, strings.Join(args, " "))
 
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		return 0, fmt.Errorf("new pipe error %v", err)
+	}
 	cmd := exec.Command("/proc/self/exe", "exec")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, oneCommand)
-	containerEnv := getEnvsByPid(pid);
-	cmd.Env = append(os.Environ(), containerEnv...)
-
-	if err := cmd.Run(); err != nil {
-		log.Errorf("Exec container %s error %v", containerName, err)
+	// 只传递nsenter需要的环境变量，用户程序的环境变量都在ExecConfig中
+	cmd.Env = []string{ENV_EXEC_PID + "=" + containerInfo.Pid}
+	cmd.ExtraFiles = []*os.File{readPipe}
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	var master, slave *os.File
+	switch {
+	case opts.detach:
+		// 标准输入输出是/dev/null，在单独的会话中运行，不受用户终端关闭的影响
+		cmd.SysProcAttr.Setsid = true
+	case opts.tty:
+		if master, slave, err = term.OpenPty(); err != nil {
+			readPipe.Close()
+			writePipe.Close()
+			return 0, err
+		}
+		defer master.Close()
+		// 容器中的进程在自己的会话中把slave设置为控制终端，见container.RunContainerExecProcess
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		if ws, err := term.GetWinsize(os.Stdin.Fd()); err == nil {
+			term.SetWinsize(master.Fd(), ws)
+		}
+	default:
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	}
+	err = cmd.Start()
+	if slave != nil {
+		// 关闭这里slave的副本，容器中的进程都退出后读master才会返回
+		slave.Close()
+	}
+	if err != nil {
+		readPipe.Close()
+		writePipe.Close()
+		return 0, fmt.Errorf("start exec process error %v", err)
 	}
+	// nsenter进入namespace后等待加入容器的cgroup，之后才fork出执行用户程序的进程
+	err = cgroups.JoinProcessCgroups(pid, cmd.Process.Pid)
+	if err == nil {
+		_, err = writePipe.Write([]byte{0})
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		readPipe.Close()
+		// nsenter进入namespace失败时已经写回错误退出了，报告它的错误
+		if reply, _ := ioutil.ReadAll(writePipe); len(reply) > 0 {
+			err = fmt.Errorf("%s", reply)
+		}
+		writePipe.Close()
+		return 0, fmt.Errorf("exec container %s error %v", containerName, err)
+	}
+	if err := sendConfig(cmd, config, writePipe); err != nil {
+		cmd.Wait()
+		return 0, fmt.Errorf("exec container %s error %v", containerName, err)
+	}
+	if opts.detach {
+		cmd.Process.Release()
+		return 0, nil
+	}
+	if master != nil {
+		wait := container.RelayConsole(master)
+		defer wait()
+	}
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("wait exec process error %v", err)
+	}
+	return 0, nil
 }
 
-
-func getEnvsByPid(pid string) []string {
-	environ := fmt.Sprintf("/proc/%s/environ",pid)
-	context,err := ioutil.ReadFile(environ)
+// 容器的环境变量，也就是容器中用户程序启动时的环境变量
+func getEnvsByPid(pid string) ([]string, error) {
+	environ := fmt.Sprintf("/proc/%s/environ", pid)
+	content, err := ioutil.ReadFile(environ)
 	if err != nil {
-		log.Errorf("read /proc/%s/environ failed: %v",environ,err)
-		return nil
+		return nil, fmt.Errorf("read %s error %v", environ, err)
 	}
-	envs := strings.Split(string(context), "\u0000")
-	return envs
-}
\ No newline at end of file
+	var envs []string
+	for _, env := range bytes.Split(content, []byte{0}) {
+		if len(env) > 0 {
+			envs = append(envs, string(env))
+		}
+	}
+	return envs, nil
+}
+
+// 用-e的环境变量覆盖容器中的同名环境变量，只有名字没有值的从当前的环境变量中取值
+func mergeEnv(environ []string, overrides []string) []string {
+	env := append([]string{}, environ...)
+	for _, override := range overrides {
+		key, _, ok := strings.Cut(override, "=")
+		if !ok {
+			value, found := os.LookupEnv(key)
+			if !found {
+				continue
+			}
+			override = key + "=" + value
+		}
+		replaced := false
+		for i, e := range env {
+			if strings.HasPrefix(e, key+"=") {
+				env[i], replaced = override, true
+			}
+		}
+		if !replaced {
+			env = append(env, override)
+		}
+	}
+	return env
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"example/mydocker/archive"
+	"example/mydocker/paths"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 将容器合并后的rootfs(即overlay的mnt挂载点)打包成tar，output为空或者-时输出到标准输出
+func ExportContainer(containerName string, output string) error {
+	if _, err := getContainerInfo(containerName); err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	mntURL := paths.MntUrl(containerName)
+
+	var w io.Writer = os.Stdout
+	if output == "" || output == "-" {
+		// tar流占用了标准输出，日志改为输出到标准错误，避免混进tar包
+		log.SetOutput(os.Stderr)
+	} else {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("create export file %s error %v", output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := archive.Tar(mntURL, w, &archive.Options{Progress: archive.LogProgress("export " + containerName)}); err != nil {
+		return fmt.Errorf("tar rootfs %s error %v", mntURL, err)
+	}
+	log.Debugf("export container %s to %s", containerName, output)
+	return nil
+}
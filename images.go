@@ -0,0 +1,154 @@
+package main
+
+import (
+	"example/mydocker/container"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 给镜像打一个新的tag，source可以是 name:tag、name@sha256:xxx 或者镜像id
+func TagImage(source string, target string) error {
+	id, err := container.ResolveImage(source)
+	if err != nil {
+		return err
+	}
+	ref, err := container.ParseReference(target)
+	if err != nil {
+		return err
+	}
+	return container.TagImage(id, ref)
+}
+
+// 删除镜像，镜像还有其他tag时只删除指定的tag；镜像被容器使用时需要force才能删除，
+// 此时只删除tag，镜像数据留给容器继续使用
+func RemoveImage(name string, force bool) error {
+	id, err := container.ResolveImage(name)
+	if err != nil {
+		return err
+	}
+	refs, err := container.ImageReferences(id)
+	if err != nil {
+		return err
+	}
+	// pull时记录的 name@digest 不算tag，镜像的最后一个tag删除时随镜像一起删除
+	var tags []string
+	for _, r := range refs {
+		if !strings.Contains(r, "@") {
+			tags = append(tags, r)
+		}
+	}
+	ref, err := container.ParseReference(name)
+	byTag := err == nil && ref.Digest == "" && containsString(tags, ref.String())
+	if byTag && len(tags) > 1 {
+		if err := container.UntagImage(ref); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", ref)
+		return nil
+	}
+	if !byTag && len(tags) > 1 && !force {
+		return fmt.Errorf("image %s is referenced in multiple repositories, use -f to remove it", id[:12])
+	}
+
+	users, err := imageUsers(id)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 && !force {
+		return fmt.Errorf("image %s is being used by container %s, use -f to remove it", name, strings.Join(users, ","))
+	}
+	for _, r := range refs {
+		tagRef, err := container.ParseReference(r)
+		if err != nil {
+			return err
+		}
+		if err := container.UntagImage(tagRef); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", r)
+	}
+	if len(users) > 0 {
+		log.Warnf("image %s is still used by container %s, keep its layers", id[:12], strings.Join(users, ","))
+		return nil
+	}
+	if err := container.DeleteImage(id); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted: %s\n", id)
+	return nil
+}
+
+// 返回使用该镜像的容器名
+func imageUsers(id string) ([]string, error) {
+	containerInfos, err := getContainerInfos()
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, info := range containerInfos {
+		if info.Image == id {
+			users = append(users, info.Name)
+		}
+	}
+	return users, nil
+}
+
+func ListImages() {
+	repos, err := container.ListReferences()
+	if err != nil {
+		log.Errorf("list images error %v", err)
+		return
+	}
+	// 同一个仓库中有tag的镜像不再列出它的digest，只有digest的镜像tag显示为<none>
+	tagged := map[string]bool{}
+	for name, id := range repos {
+		if ref, err := container.ParseReference(name); err == nil && ref.Digest == "" {
+			tagged[ref.Name+"@"+id] = true
+		}
+	}
+	var names []string
+	for name, id := range repos {
+		if ref, err := container.ParseReference(name); err == nil && ref.Digest != "" && tagged[ref.Name+"@"+id] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\n")
+	for _, name := range names {
+		ref, err := container.ParseReference(name)
+		if err != nil {
+			log.Errorf("parse reference %s error %v", name, err)
+			continue
+		}
+		id := repos[name]
+		var created string
+		if config, err := container.ReadImageConfig(id); err == nil {
+			created = config.Created
+		}
+		tag := ref.Tag
+		if tag == "" {
+			tag = "<none>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ref.Name, tag, id[:12], created)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("flush tabwriter error %v", err)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
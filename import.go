@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"example/mydocker/container"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 将export导出的tar包导入为只有一层的镜像，tarPath为-时从标准输入读取
+// changes支持 CMD 和 ENV 两种指令，用来设置镜像的运行配置
+func ImportImage(tarPath string, imageName string, changes []string) error {
+	ref, err := container.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+	config := &container.ImageConfig{}
+	for _, change := range changes {
+		if err := applyImageChange(config, change); err != nil {
+			return err
+		}
+	}
+
+	var src io.Reader = os.Stdin
+	if tarPath != "-" {
+		file, err := os.Open(tarPath)
+		if err != nil {
+			return fmt.Errorf("open %s error %v", tarPath, err)
+		}
+		defer file.Close()
+		src = file
+	}
+	layer, err := container.CreateLayerFromTar(src)
+	if err != nil {
+		return fmt.Errorf("import %s error %v", tarPath, err)
+	}
+	config.Layers = []string{layer}
+	id, err := container.CreateImage(config)
+	if err != nil {
+		return err
+	}
+	if err := container.TagImage(id, ref); err != nil {
+		return err
+	}
+	log.Debugf("import %s as image %s", tarPath, ref)
+	fmt.Println(id)
+	return nil
+}
+
+// 解析 --change 指令，格式与Dockerfile相同，如 CMD ["top","-b"]、CMD top -b、ENV KEY=VALUE
+func applyImageChange(config *container.ImageConfig, change string) error {
+	fields := strings.SplitN(strings.TrimSpace(change), " ", 2)
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid change directive: %s", change)
+	}
+	instruction, args := strings.ToUpper(fields[0]), strings.TrimSpace(fields[1])
+	switch instruction {
+	case "CMD":
+		if strings.HasPrefix(args, "[") {
+			var cmd []string
+			if err := json.Unmarshal([]byte(args), &cmd); err != nil {
+				return fmt.Errorf("invalid CMD %s: %v", args, err)
+			}
+			config.Cmd = cmd
+		} else {
+			config.Cmd = strings.Fields(args)
+		}
+	case "ENV":
+		if strings.Contains(args, "=") {
+			config.Env = append(config.Env, strings.Fields(args)...)
+		} else {
+			kv := strings.SplitN(args, " ", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid ENV %s", args)
+			}
+			config.Env = append(config.Env, kv[0]+"="+strings.TrimSpace(kv[1]))
+		}
+	default:
+		return fmt.Errorf("unsupported change directive: %s", instruction)
+	}
+	return nil
+}
@@ -3,33 +3,22 @@ package main
 import (
 	"encoding/json"
 	"example/mydocker/container"
+	"example/mydocker/paths"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"text/tabwriter"
 
 	log "github.com/sirupsen/logrus"
 )
 
 func ListContainers() {
-	configPath := fmt.Sprintf(container.DefaultInfoLocation, "")
-	// 去掉“/var/run/mydocker//”最后的/，感觉这种写法不太自然
-	configPath = configPath[:len(configPath)-1]
-	files, err := ioutil.ReadDir(configPath)
+	containerInfos, err := getContainerInfos()
 	if err != nil {
 		log.Errorf("read configPath error %v", err)
 		return
 	}
-
-	var containerInfos []*container.ContainerInfo
-	for _, file := range files {
-		tmpInfo, err := getContainerInfo(file.Name())
-		if err != nil {
-			log.Errorf("getContainerInfo error %v", err)
-			continue
-		}
-		containerInfos = append(containerInfos, tmpInfo)
-	}
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
 	for _, item := range containerInfos {
@@ -47,10 +36,30 @@ func ListContainers() {
 	}
 }
 
+// 读取所有容器的信息，读取失败的容器会被跳过
+func getContainerInfos() ([]*container.ContainerInfo, error) {
+	files, err := ioutil.ReadDir(paths.ContainersUrl())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var containerInfos []*container.ContainerInfo
+	for _, file := range files {
+		tmpInfo, err := getContainerInfo(file.Name())
+		if err != nil {
+			log.Errorf("getContainerInfo error %v", err)
+			continue
+		}
+		containerInfos = append(containerInfos, tmpInfo)
+	}
+	return containerInfos, nil
+}
+
 func getContainerInfo(containerName string) (*container.ContainerInfo, error) {
-	configPath := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	configPath = configPath + container.ConfigName
-	// configPath = path.Join(configPath, container.ConfigName)
+	configPath := filepath.Join(paths.ContainerUrl(containerName), container.ConfigName)
 	content, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		log.Errorf("read configPath:%s error %v", configPath, err)
@@ -63,3 +72,13 @@ func getContainerInfo(containerName string) (*container.ContainerInfo, error) {
 	}
 	return &containerInfo, err
 }
+
+// 更新容器的信息文件
+func saveContainerInfo(containerInfo *container.ContainerInfo) error {
+	jsonBytes, err := json.Marshal(containerInfo)
+	if err != nil {
+		return err
+	}
+	configPath := filepath.Join(paths.ContainerUrl(containerInfo.Name), container.ConfigName)
+	return ioutil.WriteFile(configPath, jsonBytes, 0622)
+}
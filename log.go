@@ -1,25 +1,217 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"example/mydocker/container"
+	"example/mydocker/logger"
+	"example/mydocker/paths"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-
-	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"time"
 )
 
-func LogContainer(containerName string) {
-	logDir := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	logPath := logDir + container.LogName
-	file, err := os.Open(logPath)
+// -f时检查日志文件是否有新内容的间隔
+const logPollInterval = 200 * time.Millisecond
+
+type logOptions struct {
+	follow bool
+	// 只打印最后几行，小于0时打印全部
+	tail       int
+	since      time.Time
+	until      time.Time
+	timestamps bool
+	stdout     bool
+	stderr     bool
+}
+
+func (o *logOptions) match(entry *logger.JSONLog) bool {
+	if entry.Stream == logger.Stderr && !o.stderr || entry.Stream != logger.Stderr && !o.stdout {
+		return false
+	}
+	if !o.since.IsZero() && entry.Time.Before(o.since) {
+		return false
+	}
+	return o.until.IsZero() || !entry.Time.After(o.until)
+}
+
+// 标准输出和标准错误的内容分别打印到对应的流上
+func (o *logOptions) print(entry *logger.JSONLog) {
+	out := os.Stdout
+	if entry.Stream == logger.Stderr {
+		out = os.Stderr
+	}
+	if o.timestamps {
+		fmt.Fprint(out, entry.Time.Format(time.RFC3339Nano)+" ")
+	}
+	fmt.Fprint(out, entry.Log)
+}
+
+// 打印容器的日志，--tail只对已经写入的日志生效，-f时继续打印新的日志直到容器退出
+// 只有json-file驱动的日志可以读取，轮转出的旧文件按时间顺序在前面
+func LogContainer(containerName string, opts *logOptions) error {
+	info, err := getContainerInfo(containerName)
 	if err != nil {
-		log.Errorf("read logPath:%s error %v", logPath, err)
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if info.LogConfig != nil && info.LogConfig.Driver != logger.DefaultDriver {
+		return fmt.Errorf("configured logging driver %s does not support reading", info.LogConfig.Driver)
+	}
+	logPath := filepath.Join(paths.ContainerUrl(containerName), container.LogName)
+	var tail []*logger.JSONLog
+	var reader *logReader
+	files := logger.JSONLogFiles(logPath)
+	for i, name := range files {
+		if reader, err = openLogReader(name); err != nil {
+			// 列出文件之后旧文件可能被轮转删掉了
+			if os.IsNotExist(err) && i < len(files)-1 {
+				continue
+			}
+			return err
+		}
+		for {
+			entry, err := reader.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				reader.close()
+				return err
+			}
+			if !opts.match(entry) {
+				continue
+			}
+			if opts.tail < 0 {
+				opts.print(entry)
+				continue
+			}
+			tail = append(tail, entry)
+			if len(tail) > opts.tail {
+				tail = tail[1:]
+			}
+		}
+		if i < len(files)-1 {
+			reader.close()
+		}
+	}
+	defer reader.close()
+	for _, entry := range tail {
+		opts.print(entry)
 	}
-	defer file.Close()
-	content, err := ioutil.ReadAll(file)
+	if !opts.follow {
+		return nil
+	}
+	return followLog(info, logPath, reader, opts)
+}
+
+// 等待supervisor写入新的日志，容器退出或者超过--until的时间后返回
+// 日志文件轮转后先读完旧文件中剩下的内容，再接着读新文件
+func followLog(info *container.ContainerInfo, logPath string, reader *logReader, opts *logOptions) error {
+	exited := false
+	rotated := false
+	for {
+		entry, err := reader.next()
+		if err == io.EOF {
+			if rotated {
+				err := reader.reopen(logPath)
+				if err == nil {
+					rotated = false
+					continue
+				}
+				if !os.IsNotExist(err) {
+					return err
+				}
+			} else if reader.rotated(logPath) {
+				rotated = true
+				continue
+			}
+			// 容器退出后supervisor还要写完管道中剩下的输出，等一下再读一遍就结束
+			if exited {
+				return nil
+			}
+			if !opts.until.IsZero() && time.Now().After(opts.until) {
+				return nil
+			}
+			exited = !containerAlive(info)
+			time.Sleep(logPollInterval)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !opts.until.IsZero() && entry.Time.After(opts.until) {
+			return nil
+		}
+		if opts.match(entry) {
+			opts.print(entry)
+		}
+	}
+}
+
+// 按行读取json格式的日志，supervisor正在写的最后一行不完整时返回io.EOF，已读到的部分留到下次
+type logReader struct {
+	file    *os.File
+	reader  *bufio.Reader
+	pending []byte
+}
+
+func openLogReader(path string) (*logReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &logReader{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+// path已经不是正在读的文件，说明日志轮转了
+func (r *logReader) rotated(path string) bool {
+	fi, err := os.Stat(path)
 	if err != nil {
-		log.Errorf("read logfile error %v", err)
+		return os.IsNotExist(err)
+	}
+	current, err := r.file.Stat()
+	return err == nil && !os.SameFile(fi, current)
+}
+
+// 日志轮转之后改为从头读取下一个文件，正在读的文件已经改名为.N时下一个是.N-1，
+// 两次检查之间轮转了多次也不会跳过中间的文件；正在读的文件已经被删掉时从最旧的文件开始
+func (r *logReader) reopen(path string) error {
+	files := logger.JSONLogFiles(path)
+	next := files[0]
+	if current, err := r.file.Stat(); err == nil {
+		for i, name := range files[:len(files)-1] {
+			if fi, err := os.Stat(name); err == nil && os.SameFile(fi, current) {
+				next = files[i+1]
+				break
+			}
+		}
+	}
+	file, err := os.Open(next)
+	if err != nil {
+		return err
+	}
+	r.file.Close()
+	r.file, r.pending = file, r.pending[:0]
+	r.reader.Reset(file)
+	return nil
+}
+
+func (r *logReader) close() {
+	r.file.Close()
+}
+
+func (r *logReader) next() (*logger.JSONLog, error) {
+	line, err := r.reader.ReadBytes('\n')
+	r.pending = append(r.pending, line...)
+	if err != nil {
+		return nil, err
+	}
+	var entry logger.JSONLog
+	if err := json.Unmarshal(r.pending, &entry); err != nil {
+		return nil, fmt.Errorf("parse log line %q error %v", r.pending, err)
 	}
-	fmt.Fprint(os.Stdout, string(content))
+	r.pending = r.pending[:0]
+	return &entry, nil
 }
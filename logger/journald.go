@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// systemd-journald接收原生协议日志的socket
+const journalSocket = "/run/systemd/journal/socket"
+
+// journald驱动，用journald的原生协议把日志发到journal socket，每条日志是一个数据报，
+// 除MESSAGE外还带有容器的ID、名字和标签，可以用journalctl CONTAINER_NAME=xxx查询
+type journaldDriver struct {
+}
+
+func (d *journaldDriver) Name() string {
+	return "journald"
+}
+
+func (d *journaldDriver) ValidateOpts(opts map[string]string) error {
+	return checkOpts(d.Name(), opts, "tag")
+}
+
+func (d *journaldDriver) New(info *Info) (Logger, error) {
+	return newJournaldLogger(journalSocket, info)
+}
+
+func newJournaldLogger(socket string, info *Info) (*journaldLogger, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to journald error %v", err)
+	}
+	t := tag(info)
+	return &journaldLogger{
+		conn: conn,
+		fields: [][2]string{
+			{"CONTAINER_ID", info.ContainerID},
+			{"CONTAINER_NAME", info.ContainerName},
+			{"CONTAINER_TAG", t},
+			{"SYSLOG_IDENTIFIER", t},
+		},
+	}, nil
+}
+
+type journaldLogger struct {
+	conn *net.UnixConn
+	// 每条日志都带上的字段
+	fields [][2]string
+}
+
+func (l *journaldLogger) Log(msg *Message) error {
+	// 标准输出是info级别，标准错误是err级别，和syslog的级别相同
+	priority := "6"
+	if msg.Stream == Stderr {
+		priority = "3"
+	}
+	line := string(msg.Line)
+	var buf []byte
+	if strings.HasSuffix(line, "\n") {
+		line = strings.TrimSuffix(line, "\n")
+	} else {
+		buf = appendJournalField(buf, "CONTAINER_PARTIAL_MESSAGE", "true")
+	}
+	buf = appendJournalField(buf, "MESSAGE", line)
+	buf = appendJournalField(buf, "PRIORITY", priority)
+	for _, field := range l.fields {
+		buf = appendJournalField(buf, field[0], field[1])
+	}
+	// 一行最长16K，不会超过数据报的大小限制，不需要通过memfd传递
+	_, err := l.conn.Write(buf)
+	return err
+}
+
+func (l *journaldLogger) Close() error {
+	return l.conn.Close()
+}
+
+// 原生协议中每个字段是一行KEY=value，值中有换行符时改成KEY换行后跟8字节小端的长度和值本身
+func appendJournalField(buf []byte, key string, value string) []byte {
+	buf = append(buf, key...)
+	if !strings.Contains(value, "\n") {
+		buf = append(buf, '=')
+		buf = append(buf, value...)
+		return append(buf, '\n')
+	}
+	buf = append(buf, '\n')
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(value)))
+	buf = append(buf, value...)
+	return append(buf, '\n')
+}
@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendJournalField(t *testing.T) {
+	got := string(appendJournalField(nil, "MESSAGE", "hello"))
+	if want := "MESSAGE=hello\n"; got != want {
+		t.Errorf("appendJournalField = %q, want %q", got, want)
+	}
+	got = string(appendJournalField(nil, "MESSAGE", "a\nb"))
+	if want := "MESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n"; got != want {
+		t.Errorf("appendJournalField = %q, want %q", got, want)
+	}
+}
+
+func TestJournaldLog(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	l, err := newJournaldLogger(socket, &Info{ContainerID: "1234567890", ContainerName: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		msg  *Message
+		want string
+	}{
+		{
+			msg:  &Message{Line: []byte("hello\n"), Stream: Stdout, Time: time.Now()},
+			want: "MESSAGE=hello\nPRIORITY=6\nCONTAINER_ID=1234567890\nCONTAINER_NAME=web\nCONTAINER_TAG=web\nSYSLOG_IDENTIFIER=web\n",
+		},
+		{
+			msg:  &Message{Line: []byte("part"), Stream: Stderr, Time: time.Now()},
+			want: "CONTAINER_PARTIAL_MESSAGE=true\nMESSAGE=part\nPRIORITY=3\nCONTAINER_ID=1234567890\nCONTAINER_NAME=web\nCONTAINER_TAG=web\nSYSLOG_IDENTIFIER=web\n",
+		},
+	}
+	buf := make([]byte, 4096)
+	for _, tt := range tests {
+		if err := l.Log(tt.msg); err != nil {
+			t.Fatal(err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != tt.want {
+			t.Errorf("datagram = %q, want %q", got, tt.want)
+		}
+	}
+}
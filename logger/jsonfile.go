@@ -0,0 +1,176 @@
+package logger
+
+import (
+	"encoding/json"
+	"example/mydocker/units"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// json-file日志文件中的一行，和docker的格式相同
+type JSONLog struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// json-file驱动，日志写到容器目录下的文件中，是默认的驱动，也是logs命令能读取的驱动
+// 设置max-size后文件超过大小就轮转，旧文件依次改名为.1、.2...，最多保留max-file个文件
+type jsonFileDriver struct {
+}
+
+func (d *jsonFileDriver) Name() string {
+	return DefaultDriver
+}
+
+func (d *jsonFileDriver) ValidateOpts(opts map[string]string) error {
+	if err := checkOpts(d.Name(), opts, "max-size", "max-file"); err != nil {
+		return err
+	}
+	_, _, err := parseRotateOpts(opts)
+	return err
+}
+
+func (d *jsonFileDriver) New(info *Info) (Logger, error) {
+	maxSize, maxFile, err := parseRotateOpts(info.Opts)
+	if err != nil {
+		return nil, err
+	}
+	l := &jsonFileLogger{path: info.LogPath, maxSize: maxSize, maxFile: maxFile}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open log file:%s error %v", l.path, err)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.file, l.size = file, fi.Size()
+	return l, nil
+}
+
+// max-size为0时不轮转，max-file默认是1，轮转时直接换成新文件
+func parseRotateOpts(opts map[string]string) (int64, int, error) {
+	var maxSize int64
+	maxFile := 1
+	if s, ok := opts["max-size"]; ok {
+		size, err := units.ParseSize(s)
+		if err != nil || size <= 0 {
+			return 0, 0, fmt.Errorf("invalid max-size %s", s)
+		}
+		maxSize = size
+	}
+	if s, ok := opts["max-file"]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid max-file %s", s)
+		}
+		if maxSize == 0 {
+			return 0, 0, fmt.Errorf("max-file requires max-size")
+		}
+		maxFile = n
+	}
+	return maxSize, maxFile, nil
+}
+
+type jsonFileLogger struct {
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64
+	maxFile int
+}
+
+func (l *jsonFileLogger) Log(msg *Message) error {
+	line, err := json.Marshal(&JSONLog{Log: string(msg.Line), Stream: msg.Stream, Time: msg.Time})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	var rotateErr error
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		// 轮转失败时继续写原来的文件，日志不会丢，下一条日志再尝试轮转
+		if err := l.rotate(); err != nil {
+			rotateErr = fmt.Errorf("rotate log file %s error %v", l.path, err)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// 当前文件改名为.1，已有的轮转文件依次后移，超出max-file的删掉
+// 轮转后总是创建新的文件而不是清空原文件，logs -f发现文件变了就去读新文件
+// 新文件先以临时文件创建，最后才替换l.file，中间任何一步失败l.file都还能继续写
+func (l *jsonFileLogger) rotate() error {
+	tmpPath := l.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := l.shift(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	// max-file为1时直接替换掉当前文件
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	l.file.Close()
+	l.file, l.size = file, 0
+	return nil
+}
+
+func (l *jsonFileLogger) shift() error {
+	if l.maxFile <= 1 {
+		return nil
+	}
+	for i := l.maxFile - 1; i > 1; i-- {
+		if err := os.Rename(rotatedLogPath(l.path, i-1), rotatedLogPath(l.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// 上次轮转到这一步之后失败时，当前文件已经是.1了
+	if err := os.Rename(l.path, rotatedLogPath(l.path, 1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *jsonFileLogger) Close() error {
+	return l.file.Close()
+}
+
+func rotatedLogPath(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
+
+// 容器的所有日志文件，按时间从旧到新排列，最后一个是正在写的文件
+// 轮转过程中编号不一定连续，所以列出所有带编号的文件，而不是从.1开始依次检查
+func JSONLogFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	var numbers []int
+	for _, match := range matches {
+		if n, err := strconv.Atoi(strings.TrimPrefix(match, path+".")); err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+	var files []string
+	for _, n := range numbers {
+		files = append(files, rotatedLogPath(path, n))
+	}
+	return append(files, path)
+}
@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJSONFileRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "container-json.log")
+	l, err := (&jsonFileDriver{}).New(&Info{LogPath: path, Opts: map[string]string{"max-size": "200", "max-file": "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每条日志大约70字节，一个文件放两条
+	for i := 0; i < 10; i++ {
+		msg := &Message{Line: []byte("line " + strconv.Itoa(i) + "\n"), Stream: Stdout, Time: time.Unix(int64(i), 0).UTC()}
+		if err := l.Log(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	files := JSONLogFiles(path)
+	if want := []string{path + ".2", path + ".1", path}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("JSONLogFiles = %v, want %v", files, want)
+	}
+	var lines []string
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, _ := file.Stat()
+		if fi.Size() > 200 {
+			t.Errorf("%s size %d exceeds max-size", name, fi.Size())
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry JSONLog
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, entry.Log)
+		}
+		file.Close()
+	}
+	want := []string{"line 4\n", "line 5\n", "line 6\n", "line 7\n", "line 8\n", "line 9\n"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("logs = %q, want %q", lines, want)
+	}
+}
+
+func TestJSONFileRotateSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "container-json.log")
+	l, err := (&jsonFileDriver{}).New(&Info{LogPath: path, Opts: map[string]string{"max-size": "100"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := l.Log(&Message{Line: []byte("line\n"), Stream: Stderr, Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+	if files := JSONLogFiles(path); len(files) != 1 {
+		t.Errorf("JSONLogFiles = %v, want only %s", files, path)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() > 100 {
+		t.Errorf("stat %s = %v, %v", path, fi, err)
+	}
+}
+
+// 轮转失败时日志继续写到原来的文件，失败的原因消除后下一次轮转成功
+func TestJSONFileRotateError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "container-json.log")
+	l, err := (&jsonFileDriver{}).New(&Info{LogPath: path, Opts: map[string]string{"max-size": "100", "max-file": "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// .1改名为.2时.2是非空的目录，改名失败
+	blocker := path + ".2"
+	if err := os.MkdirAll(filepath.Join(blocker, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".1", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Line: []byte("line\n"), Stream: Stdout, Time: time.Now()}
+	if err := l.Log(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Log(msg); err == nil {
+		t.Fatal("expect rotate error")
+	}
+	if err := l.Log(msg); err == nil {
+		t.Fatal("expect rotate error")
+	}
+	if lines := countLines(t, path); lines != 3 {
+		t.Errorf("expect 3 lines in %s after failed rotation, got %d", path, lines)
+	}
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Log(msg); err != nil {
+		t.Fatal(err)
+	}
+	if lines := countLines(t, path); lines != 1 {
+		t.Errorf("expect 1 line in %s after rotation, got %d", path, lines)
+	}
+	if lines := countLines(t, path+".1"); lines != 3 {
+		t.Errorf("expect 3 lines in %s.1 after rotation, got %d", path, lines)
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,154 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	Stdout        = "stdout"
+	Stderr        = "stderr"
+	DefaultDriver = "json-file"
+)
+
+// 容器的一行输出，Line包括结尾的换行符，超长被拆开的行只有最后一段带换行符
+type Message struct {
+	Line   []byte
+	Stream string
+	Time   time.Time
+}
+
+// 日志驱动为每个容器创建的Logger，由supervisor调用，不需要支持并发
+type Logger interface {
+	Log(msg *Message) error
+	Close() error
+}
+
+// 容器使用的日志驱动和--log-opt选项，记录在容器信息中
+type Config struct {
+	Driver string            `json:"driver"`
+	Opts   map[string]string `json:"opts,omitempty"`
+}
+
+// 创建Logger需要的容器信息
+type Info struct {
+	ContainerID   string
+	ContainerName string
+	// json-file驱动写入的日志文件
+	LogPath string
+	Opts    map[string]string
+}
+
+type Driver interface {
+	Name() string
+	// 检查--log-opt，在启动容器之前调用，不支持的选项和错误的值都返回错误
+	ValidateOpts(opts map[string]string) error
+	New(info *Info) (Logger, error)
+}
+
+var drivers = map[string]Driver{}
+
+func init() {
+	for _, driver := range []Driver{&jsonFileDriver{}, &noneDriver{}, &syslogDriver{}, &journaldDriver{}} {
+		drivers[driver.Name()] = driver
+	}
+}
+
+func GetDriver(name string) (Driver, error) {
+	if name == "" {
+		name = DefaultDriver
+	}
+	driver, ok := drivers[name]
+	if !ok {
+		var names []string
+		for n := range drivers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown log driver %s, supported drivers: %s", name, strings.Join(names, ", "))
+	}
+	return driver, nil
+}
+
+// 解析--log-opt，每个值是key=value，也可以用逗号分隔多个，如 max-size=10m,max-file=3
+func ParseConfig(driver string, logOpts []string) (*Config, error) {
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	d, err := GetDriver(driver)
+	if err != nil {
+		return nil, err
+	}
+	opts := map[string]string{}
+	for _, logOpt := range logOpts {
+		for _, opt := range strings.Split(logOpt, ",") {
+			key, value, ok := strings.Cut(opt, "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid log opt %s, should be key=value", opt)
+			}
+			opts[key] = value
+		}
+	}
+	if err := d.ValidateOpts(opts); err != nil {
+		return nil, err
+	}
+	config := &Config{Driver: driver}
+	if len(opts) > 0 {
+		config.Opts = opts
+	}
+	return config, nil
+}
+
+// 检查opts中只有驱动支持的选项
+func checkOpts(driver string, opts map[string]string, supported ...string) error {
+	for key := range opts {
+		found := false
+		for _, s := range supported {
+			if key == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown log opt %s for log driver %s", key, driver)
+		}
+	}
+	return nil
+}
+
+// 日志中使用的标签，默认是容器名
+func tag(info *Info) string {
+	if t := info.Opts["tag"]; t != "" {
+		return t
+	}
+	return info.ContainerName
+}
+
+// none驱动丢弃容器的所有输出
+type noneDriver struct {
+}
+
+func (d *noneDriver) Name() string {
+	return "none"
+}
+
+func (d *noneDriver) ValidateOpts(opts map[string]string) error {
+	return checkOpts(d.Name(), opts)
+}
+
+func (d *noneDriver) New(info *Info) (Logger, error) {
+	return &noneLogger{}, nil
+}
+
+type noneLogger struct {
+}
+
+func (l *noneLogger) Log(msg *Message) error {
+	return nil
+}
+
+func (l *noneLogger) Close() error {
+	return nil
+}
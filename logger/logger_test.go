@@ -0,0 +1,42 @@
+package logger
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		driver  string
+		logOpts []string
+		want    *Config
+		err     bool
+	}{
+		{driver: "", want: &Config{Driver: "json-file"}},
+		{driver: "json-file", logOpts: []string{"max-size=10m,max-file=3"}, want: &Config{Driver: "json-file", Opts: map[string]string{"max-size": "10m", "max-file": "3"}}},
+		{driver: "json-file", logOpts: []string{"max-size=10m", "max-file=3"}, want: &Config{Driver: "json-file", Opts: map[string]string{"max-size": "10m", "max-file": "3"}}},
+		{driver: "syslog", logOpts: []string{"syslog-address=unixgram:///dev/log", "tag=web"}, want: &Config{Driver: "syslog", Opts: map[string]string{"syslog-address": "unixgram:///dev/log", "tag": "web"}}},
+		{driver: "journald", logOpts: []string{"tag=web"}, want: &Config{Driver: "journald", Opts: map[string]string{"tag": "web"}}},
+		{driver: "none", want: &Config{Driver: "none"}},
+		{driver: "fluentd", err: true},
+		{driver: "none", logOpts: []string{"tag=web"}, err: true},
+		{driver: "json-file", logOpts: []string{"max-size"}, err: true},
+		{driver: "json-file", logOpts: []string{"max-size=abc"}, err: true},
+		{driver: "json-file", logOpts: []string{"max-file=3"}, err: true},
+		{driver: "json-file", logOpts: []string{"max-size=1m,max-file=0"}, err: true},
+		{driver: "syslog", logOpts: []string{"syslog-address=tcp://127.0.0.1:514"}, err: true},
+		{driver: "syslog", logOpts: []string{"syslog-facility=nope"}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseConfig(tt.driver, tt.logOpts)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseConfig(%q, %q) = %+v, want error", tt.driver, tt.logOpts, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseConfig(%q, %q) = %+v, %v, want %+v", tt.driver, tt.logOpts, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// syslog驱动，把日志发给本机的syslog服务，标准输出是info级别，标准错误是err级别
+// syslog-address是unix://或者unixgram://开头的socket路径，不指定时使用/dev/log等默认位置
+type syslogDriver struct {
+}
+
+func (d *syslogDriver) Name() string {
+	return "syslog"
+}
+
+func (d *syslogDriver) ValidateOpts(opts map[string]string) error {
+	if err := checkOpts(d.Name(), opts, "syslog-address", "syslog-facility", "tag"); err != nil {
+		return err
+	}
+	if _, _, err := parseSyslogAddress(opts["syslog-address"]); err != nil {
+		return err
+	}
+	_, err := parseSyslogFacility(opts["syslog-facility"])
+	return err
+}
+
+func (d *syslogDriver) New(info *Info) (Logger, error) {
+	network, addr, err := parseSyslogAddress(info.Opts["syslog-address"])
+	if err != nil {
+		return nil, err
+	}
+	facility, err := parseSyslogFacility(info.Opts["syslog-facility"])
+	if err != nil {
+		return nil, err
+	}
+	writer, err := syslog.Dial(network, addr, facility|syslog.LOG_INFO, tag(info))
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog error %v", err)
+	}
+	return &syslogLogger{writer: writer}, nil
+}
+
+func parseSyslogAddress(address string) (string, string, error) {
+	if address == "" {
+		return "", "", nil
+	}
+	for _, network := range []string{"unix", "unixgram"} {
+		if path := strings.TrimPrefix(address, network+"://"); path != address && path != "" {
+			return network, path, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid syslog-address %s, only local unix sockets are supported, e.g. unix:///dev/log", address)
+}
+
+func parseSyslogFacility(facility string) (syslog.Priority, error) {
+	if facility == "" {
+		return syslog.LOG_DAEMON, nil
+	}
+	priority, ok := syslogFacilities[facility]
+	if !ok {
+		return 0, fmt.Errorf("invalid syslog-facility %s", facility)
+	}
+	return priority, nil
+}
+
+type syslogLogger struct {
+	writer *syslog.Writer
+}
+
+func (l *syslogLogger) Log(msg *Message) error {
+	line := strings.TrimSuffix(string(msg.Line), "\n")
+	if msg.Stream == Stderr {
+		return l.writer.Err(line)
+	}
+	return l.writer.Info(line)
+}
+
+func (l *syslogLogger) Close() error {
+	return l.writer.Close()
+}
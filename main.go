@@ -1,6 +1,7 @@
 package main
 
 import (
+	"example/mydocker/paths"
 	"os"
 
 	log "github.com/sirupsen/logrus"
@@ -18,21 +19,59 @@ func main() {
 	app.Usage = usage
 	app.Commands = []cli.Command{
 		initCommand,
+		superviseCommand,
+		chrootArchiveCommand,
 		runCommand,
 		commitCommand,
+		diffCommand,
+		copyCommand,
+		exportCommand,
+		importCommand,
+		buildCommand,
+		pullCommand,
+		pushCommand,
+		tagCommand,
+		imagesCommand,
+		removeImageCommand,
 		listCommand,
 		logCommand,
 		execCommand,
+		attachCommand,
 		stopCommand,
 		removeCommand,
+		volumeCommand,
 		networkCommand,
+		systemCommand,
+	}
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "config file, default " + paths.DefaultConfigFile + " or $" + paths.EnvConfigFile,
+		},
+		cli.StringFlag{
+			Name:  "root",
+			Usage: "root directory of images and container layers, default " + paths.DefaultRoot + " or $" + paths.EnvRoot,
+		},
+		cli.StringFlag{
+			Name:  "state-dir",
+			Usage: "directory of container and network state, default " + paths.DefaultStateDir + " or $" + paths.EnvStateDir,
+		},
+		cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "storage driver of container rootfs, overlay or vfs, default " + paths.DefaultStorageDriver + " or $" + paths.EnvStorageDriver,
+		},
 	}
 
 	app.Before = func(context *cli.Context) error {
 		log.SetFormatter(&log.JSONFormatter{})
 		log.SetOutput(os.Stdout)
 		log.SetLevel(log.DebugLevel)
-		return nil
+		// 容器的init进程、exec进入容器的进程和cp chroot后的子进程不访问存储目录，不需要加载配置
+		if context.Args().First() == initCommand.Name || context.Args().First() == chrootArchiveCommand.Name || os.Getenv(ENV_EXEC_PID) != "" {
+			return nil
+		}
+		return paths.Load(context.GlobalString("config"), context.GlobalString("root"), context.GlobalString("state-dir"), context.GlobalString("storage-driver"))
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -3,9 +3,14 @@ package main
 import (
 	"example/mydocker/cgroups/subsystems"
 	"example/mydocker/container"
+	"example/mydocker/logger"
 	"example/mydocker/network"
+	"example/mydocker/units"
+	"example/mydocker/volume"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -19,9 +24,29 @@ var runCommand = cli.Command{
 			Name:  "ti",
 			Usage: "enable tty",
 		},
-		cli.StringFlag{
+		cli.BoolFlag{
+			Name:  "i",
+			Usage: "keep STDIN open even if not attached",
+		},
+		cli.StringSliceFlag{
 			Name:  "v",
-			Usage: "volume",
+			Usage: "bind mount a volume, source:destination[:ro|rw][,propagation], source is a host path or a volume name",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs directory, destination[:options], e.g. /run:size=64m,mode=1777",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the container's root filesystem as read only",
+		},
+		cli.StringFlag{
+			Name:  "shm-size",
+			Usage: "size of /dev/shm, e.g. 128m, default 64m",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, hostPath[:containerPath][:permissions]",
 		},
 		cli.BoolFlag{
 			Name:  "d",
@@ -55,10 +80,23 @@ var runCommand = cli.Command{
 			Name: "p",
 			Usage: "port mapping",
 		},
+		cli.StringSliceFlag{
+			Name:  "storage-opt",
+			Usage: "storage options of the write layer, e.g. size=10G",
+		},
+		cli.StringFlag{
+			Name:  "log-driver",
+			Value: logger.DefaultDriver,
+			Usage: "logging driver of the container, json-file, none, syslog or journald",
+		},
+		cli.StringSliceFlag{
+			Name:  "log-opt",
+			Usage: "log driver options, e.g. max-size=10m,max-file=3",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
-			return fmt.Errorf("missing container command")
+			return fmt.Errorf("missing image name")
 		}
 		// context.Args()代表flag处后面的内容
 		var cmd []string
@@ -66,26 +104,89 @@ var runCommand = cli.Command{
 			cmd = append(cmd, arg)
 		}
 		tty := context.Bool("ti")
+		interactive := context.Bool("i")
+		// -d和-ti、-i一起使用时容器在后台运行，之后可以用attach连接
 		detach := context.Bool("d")
-		if tty && detach {
-			return fmt.Errorf("ti and d paramter can not both provided")
-		}
 
 		resConf := &subsystems.ResourceConfig{
 			MemoryLimit: context.String("mem"),
 			CpuSet:      context.String("cpuset"),
 			CpuShare:    context.String("cpushare"),
 		}
-		volume := context.String("v")
+		var mounts []container.Mount
+		for _, spec := range context.StringSlice("v") {
+			m, err := container.ParseVolume(spec)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, *m)
+		}
+		for _, spec := range context.StringSlice("tmpfs") {
+			m, err := container.ParseTmpfs(spec)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, *m)
+		}
+		var devices []container.Device
+		for _, spec := range context.StringSlice("device") {
+			d, err := container.ParseDevice(spec)
+			if err != nil {
+				return err
+			}
+			devices = append(devices, *d)
+			resConf.Devices = append(resConf.Devices, d.CgroupRule())
+		}
+		var shmSize int64
+		if context.String("shm-size") != "" {
+			size, err := units.ParseSize(context.String("shm-size"))
+			if err != nil || size <= 0 {
+				return fmt.Errorf("invalid shm-size %s", context.String("shm-size"))
+			}
+			shmSize = size
+		}
+		storageOpts, err := container.ParseStorageOpts(context.StringSlice("storage-opt"))
+		if err != nil {
+			return err
+		}
+		logConfig, err := logger.ParseConfig(context.String("log-driver"), context.StringSlice("log-opt"))
+		if err != nil {
+			return err
+		}
 		containerName := context.String("name")
 		imageName := cmd[0]
 		cmd = cmd[1:]
-		environment := context.StringSlice("e")
+		// 镜像中带有运行配置时，没有指定命令就使用镜像的CMD，镜像的ENV放在前面，可以被-e覆盖
+		// 镜像设置了ENTRYPOINT时，命令作为ENTRYPOINT的参数
+		imageID, err := container.ResolveImage(imageName)
+		if err != nil {
+			return err
+		}
+		imageConfig, err := container.ReadImageConfig(imageID)
+		if err != nil {
+			return fmt.Errorf("read image %s config error %v", imageName, err)
+		}
+		if len(cmd) == 0 {
+			cmd = imageConfig.Cmd
+		}
+		cmd = append(append([]string{}, imageConfig.Entrypoint...), cmd...)
+		if len(cmd) == 0 {
+			return fmt.Errorf("missing container command")
+		}
+		environment := append(imageConfig.Env, context.StringSlice("e")...)
 
 		network := context.String("net")
 		portMapping := context.StringSlice("p")
 		
-		Run(tty, cmd, resConf, volume, containerName, imageName, environment, network, portMapping)
+		initConfig := &container.InitConfig{
+			Args:         cmd,
+			WorkingDir:   imageConfig.WorkingDir,
+			Mounts:       mounts,
+			ReadOnlyRoot: context.Bool("read-only"),
+			Devices:      devices,
+			ShmSize:      shmSize,
+		}
+		Run(tty, interactive, detach, initConfig, resConf, containerName, imageID, storageOpts, logConfig, environment, network, portMapping)
 		return nil
 	},
 }
@@ -100,6 +201,34 @@ var initCommand = cli.Command{
 	},
 }
 
+var superviseCommand = cli.Command{
+	Name:  "supervise",
+	Usage: "Supervise a container, send its output to the log driver and serve attach. Do not call it outside",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name: "id",
+		},
+		cli.StringFlag{
+			Name: "log-driver",
+		},
+		cli.StringSliceFlag{
+			Name: "log-opt",
+		},
+		cli.BoolFlag{
+			Name: "tty",
+		},
+		cli.BoolFlag{
+			Name: "stdin",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.RunSupervisor(context.String("id"), context.Args().Get(0), context.String("log-driver"), context.StringSlice("log-opt"), context.Bool("tty"), context.Bool("stdin"))
+	},
+}
+
 var commitCommand = cli.Command{
 	Name:  "commit",
 	Usage: "commit a container into image",
@@ -109,8 +238,252 @@ var commitCommand = cli.Command{
 		}
 		containerName := context.Args().Get(0)
 		imageName := context.Args().Get(1)
-		// 此处暂时大小写无所谓，为了统一，都改成大写
-		CommitContainer(containerName,imageName)
+		if err := CommitContainer(containerName, imageName); err != nil {
+			return fmt.Errorf("commit container error: %v", err)
+		}
+		return nil
+	},
+}
+
+var diffCommand = cli.Command{
+	Name:  "diff",
+	Usage: "inspect changes to files or directories on a container's filesystem",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, json or empty for A/C/D lines",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("please input your container name")
+		}
+		if err := DiffContainer(context.Args().Get(0), context.String("format")); err != nil {
+			return fmt.Errorf("diff container error: %v", err)
+		}
+		return nil
+	},
+}
+
+var chrootArchiveCommand = cli.Command{
+	Name:  "chroot-archive",
+	Usage: "Pack or unpack a tar archive chrooted in a container's rootfs for cp. Do not call it outside",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name: "root",
+		},
+		cli.StringFlag{
+			Name: "name",
+		},
+		cli.BoolFlag{
+			Name: "untar",
+		},
+		cli.BoolFlag{
+			Name: "no-lchown",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// 标准输出可能是tar流，日志和错误都只能写到标准错误，由mydocker cp读取后报告
+		log.SetOutput(os.Stderr)
+		if len(context.Args()) < 1 {
+			fmt.Fprint(os.Stderr, "missing path")
+			os.Exit(1)
+		}
+		if err := RunChrootArchive(context.String("root"), context.Args().Get(0), context.String("name"), context.Bool("untar"), context.Bool("no-lchown")); err != nil {
+			fmt.Fprint(os.Stderr, err)
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+var copyCommand = cli.Command{
+	Name:      "cp",
+	Usage:     "copy files between a container and the host, use - to stream a tar archive from STDIN or to STDOUT",
+	ArgsUsage: "CONTAINER:SRC_PATH DEST_PATH|-  or  SRC_PATH|- CONTAINER:DEST_PATH",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "L",
+			Usage: "always follow symbol link in SRC_PATH",
+		},
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "archive mode, copy uid/gid information",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("missing source or destination path")
+		}
+		if err := CopyFiles(context.Args().Get(0), context.Args().Get(1), context.Bool("L"), context.Bool("a")); err != nil {
+			return fmt.Errorf("copy error: %v", err)
+		}
+		return nil
+	},
+}
+
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "export a container's filesystem as a tar archive",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "write to a file, instead of STDOUT",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("please input your container name")
+		}
+		containerName := context.Args().Get(0)
+		if err := ExportContainer(containerName, context.String("o")); err != nil {
+			return fmt.Errorf("export container error: %v", err)
+		}
+		return nil
+	},
+}
+
+var importCommand = cli.Command{
+	Name:  "import",
+	Usage: "import the contents from a tarball to create an image, mydocker import fs.tar image",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "change",
+			Usage: "apply CMD/ENV instruction to the created image",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("missing tar file or image name")
+		}
+		tarPath := context.Args().Get(0)
+		imageName := context.Args().Get(1)
+		if err := ImportImage(tarPath, imageName, context.StringSlice("change")); err != nil {
+			return fmt.Errorf("import image error: %v", err)
+		}
+		return nil
+	},
+}
+
+var buildCommand = cli.Command{
+	Name:  "build",
+	Usage: "build an image from a Dockerfile, mydocker build -t image [context]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "t",
+			Usage: "name of the image",
+		},
+		cli.StringFlag{
+			Name:  "f",
+			Usage: "path of the Dockerfile, default is context/Dockerfile",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use cache when building the image",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		imageName := context.String("t")
+		if imageName == "" {
+			return fmt.Errorf("missing image name")
+		}
+		contextDir := "."
+		if len(context.Args()) > 0 {
+			contextDir = context.Args().Get(0)
+		}
+		if err := BuildImage(contextDir, context.String("f"), imageName, context.Bool("no-cache")); err != nil {
+			return fmt.Errorf("build image error: %v", err)
+		}
+		return nil
+	},
+}
+
+var pullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "pull an image from a registry, mydocker pull [registry/]name[:tag|@digest]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "access the registry over plain http",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("please input your image name")
+		}
+		if err := PullImage(context.Args().Get(0), context.Bool("insecure")); err != nil {
+			return fmt.Errorf("pull image error: %v", err)
+		}
+		return nil
+	},
+}
+
+var pushCommand = cli.Command{
+	Name:  "push",
+	Usage: "push an image to a registry, mydocker push registry/name[:tag]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "access the registry over plain http",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("please input your image name")
+		}
+		if err := PushImage(context.Args().Get(0), context.Bool("insecure")); err != nil {
+			return fmt.Errorf("push image error: %v", err)
+		}
+		return nil
+	},
+}
+
+var tagCommand = cli.Command{
+	Name:  "tag",
+	Usage: "create a tag that refers to an image, mydocker tag src:tag dst:tag",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("missing source image or target image")
+		}
+		if err := TagImage(context.Args().Get(0), context.Args().Get(1)); err != nil {
+			return fmt.Errorf("tag image error: %v", err)
+		}
+		return nil
+	},
+}
+
+var removeImageCommand = cli.Command{
+	Name:  "rmi",
+	Usage: "remove one or more images",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "force removal of the image used by containers",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("please input your image name")
+		}
+		var failed []string
+		for _, imageName := range context.Args() {
+			if err := RemoveImage(imageName, context.Bool("f")); err != nil {
+				log.Errorf("remove image %s error %v", imageName, err)
+				failed = append(failed, imageName)
+			}
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("failed to remove images: %v", failed)
+		}
+		return nil
+	},
+}
+
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list all the images",
+	Action: func(context *cli.Context) error {
+		ListImages()
 		return nil
 	},
 }
@@ -125,15 +498,74 @@ var listCommand = cli.Command{
 }
 
 var logCommand = cli.Command{
-	Name:  "log",
-	Usage: "print container log",
+	Name:    "logs",
+	Aliases: []string{"log"},
+	Usage:   "print container log",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "follow log output until the container exits",
+		},
+		cli.StringFlag{
+			Name:  "tail",
+			Value: "all",
+			Usage: "number of lines to show from the end of the logs",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show logs since timestamp (e.g. 2024-01-02T15:04:05Z) or relative (e.g. 10m)",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "show logs before timestamp (e.g. 2024-01-02T15:04:05Z) or relative (e.g. 10m)",
+		},
+		cli.BoolFlag{
+			Name:  "timestamps",
+			Usage: "show timestamps",
+		},
+		cli.BoolFlag{
+			Name:  "stdout",
+			Usage: "only show stdout, default both stdout and stderr",
+		},
+		cli.BoolFlag{
+			Name:  "stderr",
+			Usage: "only show stderr, default both stdout and stderr",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("please input your container name")
 		}
 		containerName := context.Args().Get(0)
-		// 此处暂时大小写无所谓，为了统一，都改成大写
-		LogContainer(containerName)
+		opts := &logOptions{
+			follow:     context.Bool("f"),
+			tail:       -1,
+			timestamps: context.Bool("timestamps"),
+			stdout:     context.Bool("stdout") || !context.Bool("stderr"),
+			stderr:     context.Bool("stderr") || !context.Bool("stdout"),
+		}
+		if tail := context.String("tail"); tail != "all" {
+			n, err := strconv.Atoi(tail)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid tail %s", tail)
+			}
+			opts.tail = n
+		}
+		now := time.Now()
+		var err error
+		if since := context.String("since"); since != "" {
+			if opts.since, err = units.ParseTimestamp(since, now); err != nil {
+				return err
+			}
+		}
+		if until := context.String("until"); until != "" {
+			if opts.until, err = units.ParseTimestamp(until, now); err != nil {
+				return err
+			}
+		}
+		if err := LogContainer(containerName, opts); err != nil {
+			return fmt.Errorf("print container log error: %v", err)
+		}
 		return nil
 	},
 }
@@ -141,27 +573,77 @@ var logCommand = cli.Command{
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "ti",
+			Usage: "allocate a pseudo-TTY",
+		},
+		cli.BoolFlag{
+			Name:  "d",
+			Usage: "detached mode: run command in the background",
+		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set environment variables",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "username or UID, format <name|uid>[:<group|gid>]",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory inside the container",
+		},
+	},
 	Action: func(context *cli.Context) error {
-		if os.Getenv(ENV_EXEC_PID) != ""{
-			log.Infof("pid callback pid %s",os.Getpid())
-			return nil;
+		// nsenter已经进入了容器的namespace，这里是容器中的进程，错误已经通过管道交给mydocker报告
+		if os.Getenv(ENV_EXEC_PID) != "" {
+			container.RunContainerExecProcess()
+			os.Exit(1)
 		}
 		// 至少要指定两个参数
 		if len(context.Args()) < 2 {
 			return fmt.Errorf("missing container name or command")
 		}
+		opts := &execOptions{
+			tty:        context.Bool("ti"),
+			detach:     context.Bool("d"),
+			env:        context.StringSlice("e"),
+			user:       context.String("u"),
+			workingDir: context.String("w"),
+		}
+		if opts.tty && opts.detach {
+			return fmt.Errorf("ti and d paramter can not both provided")
+		}
 		containerName := context.Args().Get(0)
-		var commandArray []string
 		// 这种方式更简洁
-		commandArray = append(commandArray,context.Args().Tail()...)
-		// for _,arg := range context.Args().Tail(){
-		// 	commandArray=append(commandArray, arg)
-		// }
-		ExecContainer(containerName,commandArray)
+		commandArray := append([]string{}, context.Args().Tail()...)
+		code, err := ExecContainer(containerName, commandArray, opts)
+		if err != nil {
+			return err
+		}
+		os.Exit(code)
 		return nil
 	},
 }
 
+var attachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach to a running container's stdio, detach with Ctrl-P Ctrl-Q if it has a tty",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stdin",
+			Usage: "do not attach STDIN",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("please input your container name")
+		}
+		return AttachContainer(context.Args().Get(0), !context.Bool("no-stdin"))
+	},
+}
+
 var stopCommand = cli.Command{
 	Name:  "stop",
 	Usage: "stop a container",
@@ -188,6 +670,72 @@ var removeCommand = cli.Command{
 	},
 }
 
+var volumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "manage named volumes",
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a volume, mydocker volume create [name]",
+			Action: func(context *cli.Context) error {
+				v, err := volume.Create(context.Args().First())
+				if err != nil {
+					return fmt.Errorf("create volume error: %v", err)
+				}
+				fmt.Println(v.Name)
+				return nil
+			},
+		},
+		{
+			Name:  "ls",
+			Usage: "list volumes",
+			Action: func(context *cli.Context) error {
+				ListVolumes()
+				return nil
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "remove one or more volumes",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "f",
+					Usage: "force removal of the volume used by containers",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				var failed []string
+				for _, name := range context.Args() {
+					if err := RemoveVolume(name, context.Bool("f")); err != nil {
+						log.Errorf("remove volume %s error %v", name, err)
+						failed = append(failed, name)
+					}
+				}
+				if len(failed) > 0 {
+					return fmt.Errorf("failed to remove volumes: %v", failed)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "display detailed information on one or more volumes",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				if err := InspectVolumes(context.Args()); err != nil {
+					return fmt.Errorf("inspect volume error: %v", err)
+				}
+				return nil
+			},
+		},
+	},
+}
+
 var networkCommand = cli.Command{
 	Name:  "network",
 	Usage: "container network commands",
@@ -236,4 +784,36 @@ var networkCommand = cli.Command{
 			},
 		},
 	},
-}
\ No newline at end of file
+}
+var systemCommand = cli.Command{
+	Name:  "system",
+	Usage: "manage mydocker",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove workspaces, cgroups and network resources left by crashed containers, do not run it while containers are starting",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only list what would be removed",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return PruneSystem(context.Bool("dry-run"))
+			},
+		},
+		{
+			Name:  "df",
+			Usage: "show disk usage of images, containers and volumes",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "v",
+					Usage: "show usage of every image, container and volume",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return DiskUsage(context.Bool("v"))
+			},
+		},
+	},
+}
@@ -2,7 +2,6 @@ package network
 
 import (
 	"example/mydocker/container"
-	"fmt"
 	"log"
 	"testing"
 
@@ -80,13 +79,13 @@ func TestNet003(t *testing.T) {
     }
     // 等于 ip link add 12345 type veth peer name cif-12345
     if err = netlink.LinkAdd(&myVeth); err != nil {
-        fmt.Errorf("Error Add Endpoint Device: %v", err)
-        return
+        t.Fatalf("Error Add Endpoint Device: %v", err)
     }
+    // 测试创建的设备要删掉，否则再次运行时LinkAdd会失败
+    defer netlink.LinkDel(&myVeth)
 
     // 等于 ip link set 12345 up
     if err = netlink.LinkSetUp(&myVeth); err != nil {
-        fmt.Errorf("Error Add Endpoint Device: %v", err)
-        return
+        t.Fatalf("Error Add Endpoint Device: %v", err)
     }
 }
\ No newline at end of file
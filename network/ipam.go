@@ -2,6 +2,7 @@ package network
 
 import (
 	"encoding/json"
+	"example/mydocker/paths"
 	"net"
 	"os"
 	"path"
@@ -10,28 +11,31 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-const ipamDefaultAllocatorPath = "/var/run/mydocker/network/ipam/subnet.json"
-
 type IPAM struct {
 	SubnetAllocatorPath string
 	Subnets             *map[string]string // 网段和位图算法的数组map, key是网段，value是分配的位图数组
 }
 
-// 初始化一个 IPAM 的对象，默认使用/var/run/mydocker/network/ipam/subnet.json作为分配信息存储位置
-var ipAllocator = &IPAM{
-	SubnetAllocatorPath: ipamDefaultAllocatorPath,
+// 初始化一个 IPAM 的对象，SubnetAllocatorPath为空时使用状态目录下的network/ipam/subnet.json作为分配信息存储位置
+var ipAllocator = &IPAM{}
+
+func (ipam *IPAM) allocatorPath() string {
+	if ipam.SubnetAllocatorPath != "" {
+		return ipam.SubnetAllocatorPath
+	}
+	return paths.IPAMUrl()
 }
 
 // 加载网段地址分配信息
 func (ipam *IPAM) load() error {
-	if _, err := os.Stat(ipam.SubnetAllocatorPath); err != nil {
+	if _, err := os.Stat(ipam.allocatorPath()); err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		} else {
 			return err
 		}
 	}
-	subnetConfigFile, err := os.Open(ipam.SubnetAllocatorPath)
+	subnetConfigFile, err := os.Open(ipam.allocatorPath())
 	if err != nil {
 		return err
 	}
@@ -51,7 +55,7 @@ func (ipam *IPAM) load() error {
 
 func (ipam *IPAM) dump() error {
 	// path.Split 函数能够分隔目录和文件
-	ipamConfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
+	ipamConfigFileDir, _ := path.Split(ipam.allocatorPath())
 	if _, err := os.Stat(ipamConfigFileDir); err != nil {
 		if os.IsNotExist(err) {
 			os.MkdirAll(ipamConfigFileDir, 0644)
@@ -60,9 +64,9 @@ func (ipam *IPAM) dump() error {
 		}
 	}
 
-	subnetConfigFile, err := os.OpenFile(ipam.SubnetAllocatorPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	subnetConfigFile, err := os.OpenFile(ipam.allocatorPath(), os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		log.Errorf("OpenFile:%s error:%v", ipam.SubnetAllocatorPath, err)
+		log.Errorf("OpenFile:%s error:%v", ipam.allocatorPath(), err)
 		return err
 	}
 	defer subnetConfigFile.Close()
@@ -3,6 +3,7 @@ package network
 import (
 	"encoding/json"
 	"example/mydocker/container"
+	"example/mydocker/paths"
 	"fmt"
 	"net"
 	"os"
@@ -20,9 +21,8 @@ import (
 )
 
 var (
-	defaultNetworkPath = "/var/run/mydocker/network/network/"
-	drivers            = map[string]NetworkDriver{}
-	networks           = map[string]*Network{}
+	drivers  = map[string]NetworkDriver{}
+	networks = map[string]*Network{}
 )
 
 type Endpoint struct {
@@ -125,7 +125,7 @@ func CreateNetwork(driver, cidr, name string) error {
 	if err != nil {
 		return err
 	}
-	return nw.dump(defaultNetworkPath)
+	return nw.dump(paths.NetworksUrl())
 }
 
 func Disconnect(networkName string, cinfo *container.ContainerInfo) error {
@@ -154,20 +154,18 @@ func Init() error {
 	var bridgeDriver = BridgeNetworkDriver{}
 	drivers[bridgeDriver.Name()] = &bridgeDriver
 	// 创建网络默认配置目录
-	if _, err := os.Stat(defaultNetworkPath); err != nil {
+	networkPath := paths.NetworksUrl()
+	if _, err := os.Stat(networkPath); err != nil {
 		if os.IsNotExist(err) {
-			os.MkdirAll(defaultNetworkPath, 0644)
+			os.MkdirAll(networkPath, 0644)
 		} else {
 			return err
 		}
 	}
 
-	filepath.Walk(defaultNetworkPath, func(nwPath string, info os.FileInfo, err error) error {
+	filepath.Walk(networkPath, func(nwPath string, info os.FileInfo, err error) error {
 		// 如果是目录则跳过
-		// if info.IsDir() {
-		// 	return nil
-		// }
-		if strings.HasSuffix(nwPath, "/") {
+		if err != nil || info.IsDir() {
 			return nil
 		}
 		_, nwName := path.Split(nwPath)
@@ -201,7 +199,7 @@ func DeleteNetwork(networkName string) error {
 	if err := drivers[nw.Driver].Delete(nw); err != nil {
 		return fmt.Errorf("error Remove Network DriverError: %s", err)
 	}
-	return nw.remove(defaultNetworkPath)
+	return nw.remove(paths.NetworksUrl())
 }
 
 
@@ -248,6 +246,9 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	if err = configEndpointAddressAndRoute(ep, cinfo); err != nil {
 		return fmt.Errorf("configEndpointAddressAndRoute failed:%v",err)
 	}
+	// 记录容器的网络和ip，清理网络资源时据此判断哪些ip还在使用
+	cinfo.Network = networkName
+	cinfo.IPAddress = ip.String()
 	// 配置容器和宿主机的端口映射
 	return configPortMapping(ep, cinfo)
 }
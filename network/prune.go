@@ -0,0 +1,226 @@
+package network
+
+import (
+	"encoding/binary"
+	"example/mydocker/container"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// 清理不属于任何运行中容器的网络资源：IPAM中分配出去的ip、宿主机上的veth设备和端口映射的iptables规则
+// running是还在运行的容器，返回清理掉的资源，dryRun时只返回不清理
+func Prune(running []*container.ContainerInfo, dryRun bool) ([]string, error) {
+	if err := Init(); err != nil {
+		return nil, err
+	}
+	liveIPs := map[string]bool{}
+	liveVeths := map[string]bool{}
+	// 不知道某个运行中容器的ip时不能回收ip，否则之后会分配出重复的ip
+	reclaimIPs := true
+	for _, cinfo := range running {
+		if cinfo.IPAddress != "" {
+			liveIPs[cinfo.IPAddress] = true
+		}
+		ips, err := containerIPs(cinfo)
+		if err != nil {
+			log.Warnf("get container %s ips error %v, skip reclaiming ips", cinfo.Name, err)
+			reclaimIPs = false
+		}
+		for _, ip := range ips {
+			liveIPs[ip] = true
+		}
+		// 宿主机一端的veth以容器id的前5位命名，见BridgeNetworkDriver.Connect
+		if len(cinfo.Id) >= 5 {
+			liveVeths[cinfo.Id[:5]] = true
+		}
+	}
+
+	var pruned []string
+	if reclaimIPs {
+		ips, err := pruneIPAM(liveIPs, dryRun)
+		pruned = append(pruned, ips...)
+		if err != nil {
+			return pruned, err
+		}
+	}
+	veths, err := pruneVeths(liveVeths, dryRun)
+	pruned = append(pruned, veths...)
+	if err != nil || !reclaimIPs {
+		return pruned, err
+	}
+	rules, err := prunePortMappings(liveIPs, dryRun)
+	return append(pruned, rules...), err
+}
+
+// 从容器的network namespace中读取它的ipv4地址；容器信息中记录的ip可能缺失，
+// 比如支持记录ip之前启动的容器，或者run时保存容器信息失败的容器
+func containerIPs(cinfo *container.ContainerInfo) ([]string, error) {
+	pid, err := strconv.Atoi(cinfo.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pid %s", cinfo.Pid)
+	}
+	ns, err := netns.GetFromPid(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Delete()
+	addrs, err := handle.AddrList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, addr := range addrs {
+		if !addr.IP.IsLoopback() {
+			ips = append(ips, addr.IP.String())
+		}
+	}
+	return ips, nil
+}
+
+// 网络的网关和运行中容器的ip之外，位图中被占用的位都释放掉；网络已经删除的网段整个删除
+func pruneIPAM(liveIPs map[string]bool, dryRun bool) ([]string, error) {
+	ipAllocator.Subnets = &map[string]string{}
+	if err := ipAllocator.load(); err != nil {
+		return nil, err
+	}
+	gateways := map[string]string{}
+	for _, nw := range networks {
+		if nw.IPRange == nil {
+			continue
+		}
+		_, subnet, _ := net.ParseCIDR(nw.IPRange.String())
+		gateways[subnet.String()] = nw.IPRange.IP.String()
+	}
+
+	var pruned []string
+	for subnetStr, bitmap := range *ipAllocator.Subnets {
+		gateway, ok := gateways[subnetStr]
+		if !ok {
+			delete(*ipAllocator.Subnets, subnetStr)
+			pruned = append(pruned, "ipam subnet "+subnetStr)
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(subnetStr)
+		if err != nil {
+			return pruned, err
+		}
+		alloc := []byte(bitmap)
+		for c := range alloc {
+			if alloc[c] != '1' {
+				continue
+			}
+			ip := ipAtOffset(subnet, c).String()
+			if ip == gateway || liveIPs[ip] {
+				continue
+			}
+			alloc[c] = '0'
+			pruned = append(pruned, "ip "+ip)
+		}
+		(*ipAllocator.Subnets)[subnetStr] = string(alloc)
+	}
+	if dryRun || len(pruned) == 0 {
+		return pruned, nil
+	}
+	return pruned, ipAllocator.dump()
+}
+
+// 位图中第c位对应的ip，子网的第一个地址不分配，所以要加1
+func ipAtOffset(subnet *net.IPNet, c int) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(subnet.IP.To4())+uint32(c)+1)
+	return ip
+}
+
+// 删除连在mydocker网桥上、但不属于运行中容器的veth，容器退出后另一端随network namespace销毁，
+// 这里留下的是连接网络中途失败的容器
+func pruneVeths(liveVeths map[string]bool, dryRun bool) ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("list links error %v", err)
+	}
+	bridges := map[int]bool{}
+	for _, link := range links {
+		if _, ok := networks[link.Attrs().Name]; ok && link.Type() == "bridge" {
+			bridges[link.Attrs().Index] = true
+		}
+	}
+	var pruned []string
+	for _, link := range links {
+		if link.Type() != "veth" || !bridges[link.Attrs().MasterIndex] || liveVeths[link.Attrs().Name] {
+			continue
+		}
+		if !dryRun {
+			if err := netlink.LinkDel(link); err != nil {
+				return pruned, fmt.Errorf("delete veth %s error %v", link.Attrs().Name, err)
+			}
+		}
+		pruned = append(pruned, "veth "+link.Attrs().Name)
+	}
+	return pruned, nil
+}
+
+// 删除目标是mydocker网络中的ip、但该ip不属于运行中容器的DNAT规则，见configPortMapping
+func prunePortMappings(liveIPs map[string]bool, dryRun bool) ([]string, error) {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		log.Debugf("iptables not found, skip port mappings")
+		return nil, nil
+	}
+	output, err := exec.Command("iptables", "-t", "nat", "-S", "PREROUTING").Output()
+	if err != nil {
+		return nil, fmt.Errorf("list iptables rules error %v", err)
+	}
+	var pruned []string
+	for _, rule := range strings.Split(string(output), "\n") {
+		ip := dnatDestination(rule)
+		if ip == nil || liveIPs[ip.String()] || !inNetworks(ip) {
+			continue
+		}
+		if !dryRun {
+			args := append([]string{"-t", "nat", "-D"}, strings.Fields(rule)[1:]...)
+			if output, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+				return pruned, fmt.Errorf("delete iptables rule %s error %v: %s", rule, err, output)
+			}
+		}
+		pruned = append(pruned, "iptables rule "+rule)
+	}
+	return pruned, nil
+}
+
+// 解析iptables -S输出中DNAT规则的目标ip，如 -A PREROUTING ... -j DNAT --to-destination 10.0.0.2:80
+func dnatDestination(rule string) net.IP {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 || fields[0] != "-A" {
+		return nil
+	}
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "--to-destination" {
+			host := fields[i+1]
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return net.ParseIP(host)
+		}
+	}
+	return nil
+}
+
+func inNetworks(ip net.IP) bool {
+	for _, nw := range networks {
+		if nw.IPRange != nil && nw.IPRange.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,49 +1,96 @@
 package nsenter
 
 /*
+#define _GNU_SOURCE
 #include <errno.h>
 #include <sched.h>
+#include <signal.h>
+#include <stdarg.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <unistd.h>
+#include <sys/wait.h>
+
+// fd 3是和mydocker exec通信的管道，出错时把错误写回去，mydocker读到后报告给用户
+static void nsenter_fail(const char *format, ...) {
+    va_list args;
+    va_start(args, format);
+    vdprintf(3, format, args);
+    va_end(args);
+    exit(1);
+}
 
 // 这里的attribute ((constructor ））指的是， 一旦这个包被引用，那么这个函数就会被自动执行
 // 类似于构造函数，会在程序一启动的时候运行
+// 进入namespace必须在Go运行时启动其他线程之前完成，多线程的进程不能进入mnt namespace
 __attribute__((constructor)) void enter_namespace(void) {
     char *mydocker_pid;
     mydocker_pid = getenv("mydocker_pid");
-    if(mydocker_pid){
-        //fprintf(stdout,"got mydocker_pid=%s\n",mydocker_pid);
-    }else{
-        //fprintf(stdout,"missing mydocker pid env skip nsenter\n");
+    if(!mydocker_pid){
         return;
     }
 
-    char *mydocker_cmd;
-    mydocker_cmd = getenv("mydocker_cmd");
-    if(mydocker_cmd){
-        //fprintf(stdout,"got mydocker_cmd=%s\n",mydocker_cmd);
-    }else{
-        //fprintf(stdout,"missing mydocker cmd env skip nsenter\n");
-        return;
-    }
     char nspath[1024];
     char *namespaces[] = {"ipc","uts","net","pid","mnt"};
+    int fds[5];
+    // 先打开所有的namespace文件，进入mnt namespace之后看到的就是容器中的/proc了
     for(int i=0; i<5; i++){
-        sprintf(nspath,"/proc/%s/ns/%s",mydocker_pid,namespaces[i]);
-        int fd = open(nspath,O_RDONLY);
-        if(setns(fd,0) == -1){
-            fprintf(stderr,"setns %s namespace failed:%s\n",namespaces[i],strerror(errno));
-        }else{
-            // fprintf(stderr,"setns %s namespace succeeded\n",namespaces[i]);
+        snprintf(nspath,sizeof(nspath),"/proc/%s/ns/%s",mydocker_pid,namespaces[i]);
+        fds[i] = open(nspath,O_RDONLY|O_CLOEXEC);
+        if(fds[i] == -1){
+            nsenter_fail("open %s error %s",nspath,strerror(errno));
+        }
+    }
+    snprintf(nspath,sizeof(nspath),"/proc/%s/root",mydocker_pid);
+    int rootfd = open(nspath,O_RDONLY|O_DIRECTORY|O_CLOEXEC);
+    if(rootfd == -1){
+        nsenter_fail("open %s error %s",nspath,strerror(errno));
+    }
+    for(int i=0; i<5; i++){
+        if(setns(fds[i],0) == -1){
+            nsenter_fail("setns %s namespace error %s",namespaces[i],strerror(errno));
+        }
+        close(fds[i]);
+    }
+    // 进入mnt namespace后的根目录是namespace的根，不一定是容器进程pivot_root之后的根目录，
+    // 切换到容器进程的根目录
+    if(fchdir(rootfd) == -1 || chroot(".") == -1 || chdir("/") == -1){
+        nsenter_fail("chroot to container root error %s",strerror(errno));
+    }
+    close(rootfd);
+
+    // 等mydocker把当前进程加入容器的cgroup，之后fork出的子进程也在容器的cgroup中
+    char sync;
+    if(read(3,&sync,1) != 1){
+        exit(1);
+    }
+
+    // 进入pid namespace只对之后创建的子进程生效，所以fork出子进程回到Go中执行用户程序，
+    // 当前进程等待它退出，并以同样的退出码退出
+    pid_t child = fork();
+    if(child == -1){
+        nsenter_fail("fork error %s",strerror(errno));
+    }
+    if(child == 0){
+        return;
+    }
+    close(3);
+    // 终端的信号由子进程处理，这里忽略掉，否则用户程序还在运行时mydocker exec就返回了
+    signal(SIGINT,SIG_IGN);
+    signal(SIGQUIT,SIG_IGN);
+    signal(SIGTSTP,SIG_IGN);
+    int status;
+    while(waitpid(child,&status,0) == -1){
+        if(errno != EINTR){
+            exit(1);
         }
-        close(fd);
     }
-	fprintf(stderr,"setns namespace succeeded\n");
-    int res = system(mydocker_cmd);
-    exit(0);
-    return;
+    if(WIFSIGNALED(status)){
+        exit(128+WTERMSIG(status));
+    }
+    exit(WEXITSTATUS(status));
 }
 */
-import "C"
\ No newline at end of file
+import "C"
@@ -0,0 +1,187 @@
+package paths
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// mydocker的所有存储路径都由这里的数据目录和状态目录得到
+// 数据目录保存镜像、镜像层和容器的读写层，重启后仍然保留；
+// 状态目录保存容器信息、网络配置等运行时状态
+type Config struct {
+	Root     string `json:"root,omitempty"`
+	StateDir string `json:"state-dir,omitempty"`
+	// 创建容器rootfs使用的存储驱动
+	StorageDriver string `json:"storage-driver,omitempty"`
+}
+
+const (
+	DefaultConfigFile    = "/etc/mydocker/config.json"
+	DefaultRoot          = "/var/lib/mydocker"
+	DefaultStateDir      = "/var/run/mydocker"
+	DefaultStorageDriver = "overlay"
+
+	EnvConfigFile    = "MYDOCKER_CONFIG"
+	EnvRoot          = "MYDOCKER_ROOT"
+	EnvStateDir      = "MYDOCKER_STATE_DIR"
+	EnvStorageDriver = "MYDOCKER_STORAGE_DRIVER"
+)
+
+var current = Config{Root: DefaultRoot, StateDir: DefaultStateDir, StorageDriver: DefaultStorageDriver}
+
+// 加载配置，优先级从高到低依次是命令行参数、环境变量、配置文件、默认值
+// configFile为空时使用环境变量或者默认的配置文件，默认配置文件不存在时忽略
+func Load(configFile string, root string, stateDir string, storageDriver string) error {
+	config := Config{Root: DefaultRoot, StateDir: DefaultStateDir, StorageDriver: DefaultStorageDriver}
+	explicit := true
+	if configFile == "" {
+		configFile = os.Getenv(EnvConfigFile)
+	}
+	if configFile == "" {
+		configFile = DefaultConfigFile
+		explicit = false
+	}
+	content, err := ioutil.ReadFile(configFile)
+	if err == nil {
+		if err := json.Unmarshal(content, &config); err != nil {
+			return fmt.Errorf("parse config file %s error %v", configFile, err)
+		}
+	} else if explicit || !os.IsNotExist(err) {
+		return fmt.Errorf("read config file %s error %v", configFile, err)
+	}
+
+	config.Root = firstNonEmpty(root, os.Getenv(EnvRoot), config.Root, DefaultRoot)
+	config.StateDir = firstNonEmpty(stateDir, os.Getenv(EnvStateDir), config.StateDir, DefaultStateDir)
+	config.StorageDriver = firstNonEmpty(storageDriver, os.Getenv(EnvStorageDriver), config.StorageDriver, DefaultStorageDriver)
+	// 容器进程会切换工作目录，相对路径需要先转成绝对路径
+	if config.Root, err = filepath.Abs(config.Root); err != nil {
+		return err
+	}
+	if config.StateDir, err = filepath.Abs(config.StateDir); err != nil {
+		return err
+	}
+	current = config
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func Root() string {
+	return current.Root
+}
+
+func StateDir() string {
+	return current.StateDir
+}
+
+func StorageDriver() string {
+	return current.StorageDriver
+}
+
+func MntsUrl() string {
+	return filepath.Join(current.Root, "mnt")
+}
+
+// 容器的overlay挂载点
+func MntUrl(containerName string) string {
+	return filepath.Join(MntsUrl(), containerName)
+}
+
+func WriteLayersUrl() string {
+	return filepath.Join(current.Root, "writeLayer")
+}
+
+// 容器的读写层，即overlay的upperdir
+func WriteLayerUrl(containerName string) string {
+	return filepath.Join(WriteLayersUrl(), containerName)
+}
+
+func WorkLayersUrl() string {
+	return filepath.Join(current.Root, "work")
+}
+
+// overlay的workdir
+func WorkLayerUrl(containerName string) string {
+	return filepath.Join(WorkLayersUrl(), containerName)
+}
+
+func StoragesUrl() string {
+	return filepath.Join(current.Root, "storage")
+}
+
+// 限制了大小的读写层所在的文件系统，没有project quota时是一个loop设备上的ext4，
+// 镜像文件是同名的.img文件
+func StorageUrl(containerName string) string {
+	return filepath.Join(StoragesUrl(), containerName)
+}
+
+func LayersUrl() string {
+	return filepath.Join(current.Root, "layers")
+}
+
+func LayerUrl(id string) string {
+	return filepath.Join(LayersUrl(), id)
+}
+
+func ImagesUrl() string {
+	return filepath.Join(current.Root, "images")
+}
+
+func ImageUrl(id string) string {
+	return filepath.Join(ImagesUrl(), id+".json")
+}
+
+// 镜像名到镜像id的索引
+func RepositoriesUrl() string {
+	return filepath.Join(current.Root, "repositories.json")
+}
+
+func BuildCacheUrl(key string) string {
+	return filepath.Join(current.Root, "build-cache", key)
+}
+
+// 下载和上传镜像层时的临时文件
+func DownloadsUrl() string {
+	return filepath.Join(current.Root, "downloads")
+}
+
+func VolumesUrl() string {
+	return filepath.Join(current.Root, "volumes")
+}
+
+// 命名卷的目录，卷的数据在其中的_data目录下
+func VolumeUrl(name string) string {
+	return filepath.Join(VolumesUrl(), name)
+}
+
+func ContainersUrl() string {
+	return filepath.Join(current.StateDir, "container")
+}
+
+// 容器信息和日志所在的目录
+func ContainerUrl(containerName string) string {
+	return filepath.Join(ContainersUrl(), containerName)
+}
+
+// prune和正在创建容器的run、build之间的锁
+func PruneLockUrl() string {
+	return filepath.Join(current.StateDir, "prune.lock")
+}
+
+func NetworksUrl() string {
+	return filepath.Join(current.StateDir, "network", "network")
+}
+
+func IPAMUrl() string {
+	return filepath.Join(current.StateDir, "network", "ipam", "subnet.json")
+}
@@ -0,0 +1,41 @@
+package paths
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	defer func() { current = Config{Root: DefaultRoot, StateDir: DefaultStateDir, StorageDriver: DefaultStorageDriver} }()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(configFile, []byte(`{"root": "/data/mydocker", "state-dir": "/run/from-file", "storage-driver": "vfs"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(EnvStateDir, "/run/from-env")
+	defer os.Unsetenv(EnvStateDir)
+
+	if err := Load(configFile, "", "", ""); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if Root() != "/data/mydocker" || StateDir() != "/run/from-env" || StorageDriver() != "vfs" {
+		t.Errorf("unexpected config %+v", current)
+	}
+	if MntUrl("c1") != "/data/mydocker/mnt/c1" || ContainerUrl("c1") != "/run/from-env/container/c1" {
+		t.Errorf("unexpected paths %s %s", MntUrl("c1"), ContainerUrl("c1"))
+	}
+
+	// 命令行参数优先，相对路径转换成绝对路径
+	if err := Load(configFile, "relative", "/run/from-flag", "overlay"); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if !filepath.IsAbs(Root()) || filepath.Base(Root()) != "relative" || StateDir() != "/run/from-flag" || StorageDriver() != "overlay" {
+		t.Errorf("unexpected config %+v", current)
+	}
+
+	if err := Load(filepath.Join(dir, "missing.json"), "", "", ""); err == nil {
+		t.Errorf("expect error for missing config file")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"example/mydocker/container"
+	"example/mydocker/paths"
+	"example/mydocker/registry"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 从registry拉取镜像保存到本地镜像存储，已经存在的镜像层不会重复下载，
+// 中断的下载会在下次pull时继续
+func PullImage(imageName string, insecure bool) error {
+	ref, err := container.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+	domain, repository := registry.SplitName(ref.Name)
+	client := registry.NewClient(domain, repository, insecure)
+	reference := ref.Tag
+	if ref.Digest != "" {
+		reference = ref.Digest
+	}
+	fmt.Printf("%s: Pulling from %s\n", reference, repository)
+	manifest, manifestDigest, err := client.PullManifest(reference, registry.DefaultPlatform)
+	if err != nil {
+		return err
+	}
+	configBytes, err := client.ReadBlob(manifest.Config)
+	if err != nil {
+		return fmt.Errorf("fetch image config error %v", err)
+	}
+	imageConfig := &registry.ImageConfig{}
+	if err := json.Unmarshal(configBytes, imageConfig); err != nil {
+		return fmt.Errorf("parse image config error %v", err)
+	}
+	if len(imageConfig.RootFS.DiffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("image config has %d diff ids but manifest has %d layers", len(imageConfig.RootFS.DiffIDs), len(manifest.Layers))
+	}
+
+	config := &container.ImageConfig{
+		Cmd:        imageConfig.Config.Cmd,
+		Env:        imageConfig.Config.Env,
+		Entrypoint: imageConfig.Config.Entrypoint,
+		WorkingDir: imageConfig.Config.WorkingDir,
+		Labels:     imageConfig.Config.Labels,
+	}
+	for i, desc := range manifest.Layers {
+		diffID := strings.TrimPrefix(imageConfig.RootFS.DiffIDs[i], "sha256:")
+		if !container.LayerExists(diffID) {
+			if err := pullLayer(client, desc, diffID); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("%s: Already exists\n", shortDigest(desc.Digest))
+		}
+		config.Layers = append(config.Layers, diffID)
+	}
+
+	id, err := container.CreateImage(config)
+	if err != nil {
+		return err
+	}
+	// 记录manifest的digest，按digest拉取的镜像没有tag，之后通过 name@digest 使用
+	// 按manifest列表的digest拉取时，列表的digest也指向这个镜像
+	if err := container.SetImageDigest(id, ref.Name, manifestDigest); err != nil {
+		return err
+	}
+	if ref.Digest != "" && ref.Digest != manifestDigest {
+		if err := container.SetImageDigest(id, ref.Name, ref.Digest); err != nil {
+			return err
+		}
+	}
+	if ref.Digest == "" {
+		if err := container.TagImage(id, ref); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Digest: %s\n", manifestDigest)
+	fmt.Printf("Status: Downloaded image for %s\n", ref)
+	fmt.Println(id)
+	return nil
+}
+
+func pullLayer(client *registry.Client, desc registry.Descriptor, diffID string) error {
+	fmt.Printf("%s: Pulling fs layer\n", shortDigest(desc.Digest))
+	blobURL := filepath.Join(paths.DownloadsUrl(), strings.TrimPrefix(desc.Digest, "sha256:"))
+	if err := os.MkdirAll(filepath.Dir(blobURL), 0755); err != nil {
+		return err
+	}
+	if err := client.FetchBlob(desc, blobURL); err != nil {
+		return fmt.Errorf("download layer %s error %v", desc.Digest, err)
+	}
+	defer os.Remove(blobURL)
+	blob, err := os.Open(blobURL)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	layer, err := container.CreateLayerFromTar(blob)
+	if err != nil {
+		return fmt.Errorf("extract layer %s error %v", desc.Digest, err)
+	}
+	if layer != diffID {
+		return fmt.Errorf("layer %s diff id mismatch, expect %s, got %s", desc.Digest, diffID, layer)
+	}
+	fmt.Printf("%s: Pull complete\n", shortDigest(desc.Digest))
+	return nil
+}
+
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
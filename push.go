@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"example/mydocker/archive"
+	"example/mydocker/container"
+	"example/mydocker/paths"
+	"example/mydocker/registry"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// 把本地镜像推送到registry，镜像层打包成gzip压缩的tar，registry中已有的层不会重复上传
+func PushImage(imageName string, insecure bool) error {
+	ref, err := container.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+	if ref.Digest != "" {
+		return fmt.Errorf("can not push a digest reference %s", ref)
+	}
+	id, err := container.ResolveImage(ref.String())
+	if err != nil {
+		return err
+	}
+	config, err := container.ReadImageConfig(id)
+	if err != nil {
+		return err
+	}
+	domain, repository := registry.SplitName(ref.Name)
+	client := registry.NewClient(domain, repository, insecure)
+	fmt.Printf("The push refers to repository [%s]\n", ref.Name)
+
+	imageConfig := &registry.ImageConfig{
+		Architecture: registry.DefaultPlatform.Architecture,
+		OS:           registry.DefaultPlatform.OS,
+		Config: registry.ContainerConfig{
+			Env:        config.Env,
+			Cmd:        config.Cmd,
+			Entrypoint: config.Entrypoint,
+			WorkingDir: config.WorkingDir,
+			Labels:     config.Labels,
+		},
+		RootFS: registry.RootFS{Type: "layers"},
+	}
+	manifest := &registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+	}
+	for _, layer := range config.Layers {
+		desc, diffID, err := pushLayer(client, layer)
+		if err != nil {
+			return err
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+		imageConfig.RootFS.DiffIDs = append(imageConfig.RootFS.DiffIDs, diffID)
+	}
+
+	configBytes, err := json.Marshal(imageConfig)
+	if err != nil {
+		return err
+	}
+	manifest.Config = registry.Descriptor{
+		MediaType: registry.MediaTypeOCIConfig,
+		Digest:    registry.Digest(configBytes),
+		Size:      int64(len(configBytes)),
+	}
+	if err := client.PushBlob(manifest.Config, bytes.NewReader(configBytes)); err != nil {
+		return fmt.Errorf("push image config error %v", err)
+	}
+	digest, err := client.PushManifest(ref.Tag, manifest)
+	if err != nil {
+		return fmt.Errorf("push manifest error %v", err)
+	}
+	fmt.Printf("%s: digest: %s\n", ref.Tag, digest)
+	return nil
+}
+
+// 把镜像层打包上传，返回层的描述和diff id(未压缩tar的sha256)
+// 层目录重新打包后的tar不一定和创建层时的tar相同，所以diff id要重新计算
+func pushLayer(client *registry.Client, layer string) (registry.Descriptor, string, error) {
+	desc := registry.Descriptor{MediaType: registry.MediaTypeOCILayerGzip}
+	tmpURL := paths.DownloadsUrl()
+	if err := os.MkdirAll(tmpURL, 0755); err != nil {
+		return desc, "", err
+	}
+	blob, err := ioutil.TempFile(tmpURL, "push-")
+	if err != nil {
+		return desc, "", err
+	}
+	defer os.Remove(blob.Name())
+	defer blob.Close()
+
+	diffHasher, blobHasher := sha256.New(), sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(blob, blobHasher))
+	if err := archive.Tar(paths.LayerUrl(layer), io.MultiWriter(gz, diffHasher), &archive.Options{OverlayWhiteout: true}); err != nil {
+		return desc, "", fmt.Errorf("tar layer %s error %v", layer, err)
+	}
+	if err := gz.Close(); err != nil {
+		return desc, "", err
+	}
+	if desc.Size, err = blob.Seek(0, io.SeekCurrent); err != nil {
+		return desc, "", err
+	}
+	desc.Digest = "sha256:" + hex.EncodeToString(blobHasher.Sum(nil))
+	diffID := "sha256:" + hex.EncodeToString(diffHasher.Sum(nil))
+
+	exists, err := client.BlobExists(desc.Digest)
+	if err != nil {
+		return desc, "", err
+	}
+	if exists {
+		fmt.Printf("%s: Layer already exists\n", shortDigest(desc.Digest))
+		return desc, diffID, nil
+	}
+	if _, err := blob.Seek(0, io.SeekStart); err != nil {
+		return desc, "", err
+	}
+	if err := client.PushBlob(desc, blob); err != nil {
+		return desc, "", fmt.Errorf("push layer %s error %v", layer, err)
+	}
+	fmt.Printf("%s: Pushed\n", shortDigest(desc.Digest))
+	return desc, diffID, nil
+}
@@ -0,0 +1,83 @@
+package quota
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// 创建一个size字节的稀疏文件并格式化成ext4，通过loop设备挂载到target
+// 文件系统写满时容器里得到ENOSPC，不会占满宿主机的磁盘
+func MountLoopback(image string, target string, size int64) error {
+	f, err := os.OpenFile(image, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("create image %s error %v", image, err)
+	}
+	err = f.Truncate(size)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("truncate image %s error %v", image, err)
+	}
+	// 不保留root的块，容器能用满size
+	if output, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", image).CombinedOutput(); err != nil {
+		return fmt.Errorf("mkfs.ext4 %s error %v: %s", image, err, output)
+	}
+	loop, err := attachLoopDevice(image)
+	if err != nil {
+		return err
+	}
+	// 设置了自动释放，挂载之前不能关闭设备，否则会立即解除关联；挂载失败时关闭设备就释放了
+	defer loop.Close()
+	log.Debugf("attach %s to %s", image, loop.Name())
+	if err := unix.Mount(loop.Name(), target, "ext4", 0, ""); err != nil {
+		return fmt.Errorf("mount %s on %s error %v", loop.Name(), target, err)
+	}
+	return nil
+}
+
+// 找一个空闲的loop设备关联到image，设置LO_FLAGS_AUTOCLEAR后卸载文件系统时内核自动解除关联
+// 其他进程可能同时拿到同一个空闲设备，关联时返回EBUSY就重试
+func attachLoopDevice(image string) (*os.File, error) {
+	ctl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open loop-control error %v", err)
+	}
+	defer ctl.Close()
+	file, err := os.OpenFile(image, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	for i := 0; i < 10; i++ {
+		index, err := unix.IoctlRetInt(int(ctl.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return nil, fmt.Errorf("get free loop device error %v", err)
+		}
+		device := fmt.Sprintf("/dev/loop%d", index)
+		loop, err := os.OpenFile(device, os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("open %s error %v", device, err)
+		}
+		err = unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_SET_FD, int(file.Fd()))
+		if err == unix.EBUSY {
+			loop.Close()
+			continue
+		} else if err != nil {
+			loop.Close()
+			return nil, fmt.Errorf("attach %s to %s error %v", image, device, err)
+		}
+		info := &unix.LoopInfo64{Flags: unix.LO_FLAGS_AUTOCLEAR}
+		copy(info.File_name[:], image)
+		if err := unix.IoctlLoopSetStatus64(int(loop.Fd()), info); err != nil {
+			unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+			loop.Close()
+			return nil, fmt.Errorf("set status of %s error %v", device, err)
+		}
+		return loop, nil
+	}
+	return nil, fmt.Errorf("no free loop device for %s", image)
+}
@@ -0,0 +1,186 @@
+package quota
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// linux/fs.h和linux/dqblk_xfs.h中的定义，x/sys/unix中没有
+const (
+	fsIocFsGetXattr    = 0x801c581f
+	fsIocFsSetXattr    = 0x401c5820
+	fsXflagProjInherit = 0x200
+
+	qXSetQLim  = 'X'<<8 + 4
+	qXGetQStat = 'X'<<8 + 5
+	prjQuota   = 2
+
+	fsDquotVersion = 1
+	fsProjQuota    = 2
+	fsDqBSoft      = 1 << 2
+	fsDqBHard      = 1 << 3
+	fsQuotaPdqEnfd = 1 << 5
+
+	// 从这个id开始给容器分配project id，避开系统中其他用途常用的小id
+	firstProjectID = 10000
+	// 在数据目录中创建的块设备文件，quotactl需要文件系统所在的块设备
+	backingDevName = "backingFsBlockDev"
+)
+
+// struct fsxattr
+type fsXattr struct {
+	Xflags     uint32
+	Extsize    uint32
+	Nextents   uint32
+	Projid     uint32
+	Cowextsize uint32
+	Pad        [8]byte
+}
+
+// struct fs_disk_quota
+type fsDiskQuota struct {
+	Version      int8
+	Flags        int8
+	Fieldmask    uint16
+	ID           uint32
+	BlkHardlimit uint64
+	BlkSoftlimit uint64
+	InoHardlimit uint64
+	InoSoftlimit uint64
+	Bcount       uint64
+	Icount       uint64
+	Itimer       int32
+	Btimer       int32
+	Iwarns       uint16
+	Bwarns       uint16
+	Padding2     int32
+	RtbHardlimit uint64
+	RtbSoftlimit uint64
+	Rtbcount     uint64
+	Rtbtimer     int32
+	Rtbwarns     uint16
+	Padding3     int16
+	Padding4     [8]byte
+}
+
+func quotactl(cmd int, device string, id uint32, addr unsafe.Pointer) error {
+	devicePtr, err := unix.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd<<8|prjQuota), uintptr(unsafe.Pointer(devicePtr)), uintptr(id), uintptr(addr), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// 在dir下创建指向dir所在文件系统的块设备文件，用于quotactl
+func backingDevice(dir string) (string, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(dir, &stat); err != nil {
+		return "", err
+	}
+	device := filepath.Join(dir, backingDevName)
+	os.Remove(device)
+	if err := unix.Mknod(device, unix.S_IFBLK|0600, int(stat.Dev)); err != nil {
+		return "", fmt.Errorf("mknod %s error %v", device, err)
+	}
+	return device, nil
+}
+
+// dir所在的文件系统是否开启了project quota(XFS的prjquota或者ext4的project和prjquota)
+func ProjectQuotaSupported(dir string) bool {
+	device, err := backingDevice(dir)
+	if err != nil {
+		return false
+	}
+	// struct fs_quota_stat的qs_flags在偏移2的位置
+	stat := make([]byte, 256)
+	if err := quotactl(qXGetQStat, device, 0, unsafe.Pointer(&stat[0])); err != nil {
+		return false
+	}
+	flags := *(*uint16)(unsafe.Pointer(&stat[2]))
+	return flags&fsQuotaPdqEnfd != 0
+}
+
+func getProjectID(dir string) (uint32, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var attr fsXattr
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return 0, fmt.Errorf("get project id of %s error %v", dir, errno)
+	}
+	return attr.Projid, nil
+}
+
+// 设置目录的project id，PROJINHERIT让目录中新建的文件和目录继承这个id
+func setProjectID(dir string, id uint32) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var attr fsXattr
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return fmt.Errorf("get project id of %s error %v", dir, errno)
+	}
+	attr.Projid = id
+	attr.Xflags |= fsXflagProjInherit
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsSetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return fmt.Errorf("set project id of %s error %v", dir, errno)
+	}
+	return nil
+}
+
+// 给dirs分配一个没有被parent下其他目录使用的project id，并把它们的总大小限制为size字节
+// dirs必须在同一个文件系统中，通常是容器的读写层和overlay的workdir
+func SetProjectQuota(parent string, dirs []string, size int64) error {
+	device, err := backingDevice(parent)
+	if err != nil {
+		return err
+	}
+	used := map[uint32]bool{}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if id, err := getProjectID(filepath.Join(parent, entry.Name())); err == nil {
+			used[id] = true
+		}
+	}
+	id := uint32(firstProjectID)
+	for used[id] {
+		id++
+	}
+	for _, dir := range dirs {
+		if err := setProjectID(dir, id); err != nil {
+			return err
+		}
+	}
+	// 限制以512字节的块为单位
+	blocks := uint64(size+511) / 512
+	limit := fsDiskQuota{
+		Version:      fsDquotVersion,
+		Flags:        fsProjQuota,
+		Fieldmask:    fsDqBSoft | fsDqBHard,
+		ID:           id,
+		BlkHardlimit: blocks,
+		BlkSoftlimit: blocks,
+	}
+	if err := quotactl(qXSetQLim, device, id, unsafe.Pointer(&limit)); err != nil {
+		return fmt.Errorf("set quota of project %d error %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,487 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	// 不带registry地址的镜像名默认从docker hub拉取
+	DefaultDomain = "docker.io"
+	// docker hub的API地址和镜像名中的域名不一样
+	defaultAPIHost = "registry-1.docker.io"
+
+	maxManifestSize = 4 << 20
+)
+
+var manifestAccept = []string{
+	MediaTypeOCIIndex,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeDockerManifest,
+}
+
+// 访问一个镜像仓库的OCI distribution API客户端
+type Client struct {
+	baseURL    string
+	repository string
+	client     *http.Client
+	// 通过WWW-Authenticate中的realm获取的bearer token
+	token string
+}
+
+// 把镜像名拆分成registry地址和仓库名，如localhost:5000/app拆成localhost:5000和app，
+// busybox拆成docker.io和library/busybox
+func SplitName(name string) (string, string) {
+	domain, repository := DefaultDomain, name
+	if i := strings.Index(name, "/"); i != -1 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			domain, repository = first, name[i+1:]
+		}
+	}
+	if domain == DefaultDomain && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return domain, repository
+}
+
+// insecure为true时使用http访问registry，用于本地没有证书的registry
+func NewClient(domain string, repository string, insecure bool) *Client {
+	host := domain
+	if host == DefaultDomain {
+		host = defaultAPIHost
+	}
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	return &Client{
+		baseURL:    scheme + "://" + host,
+		repository: repository,
+		client:     &http.Client{},
+	}
+}
+
+func (c *Client) url(format string, args ...interface{}) string {
+	return c.baseURL + "/v2/" + c.repository + fmt.Sprintf(format, args...)
+}
+
+// 获取manifest，reference是tag或者digest，按digest获取时会校验内容
+// 返回manifest的内容和mediaType
+func (c *Client) FetchManifest(reference string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("/manifests/%s", reference), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestAccept, ", "))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, "", err
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > maxManifestSize {
+		return nil, "", fmt.Errorf("manifest %s is too large", reference)
+	}
+	if strings.HasPrefix(reference, "sha256:") && Digest(body) != reference {
+		return nil, "", fmt.Errorf("manifest digest mismatch, expect %s, got %s", reference, Digest(body))
+	}
+	return body, manifestMediaType(body, resp.Header.Get("Content-Type")), nil
+}
+
+// 获取镜像的manifest，reference指向manifest列表时选择platform对应的manifest
+// 返回manifest和它的digest
+func (c *Client) PullManifest(reference string, platform Platform) (*Manifest, string, error) {
+	body, mediaType, err := c.FetchManifest(reference)
+	if err != nil {
+		return nil, "", err
+	}
+	if mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList {
+		index := &Index{}
+		if err := json.Unmarshal(body, index); err != nil {
+			return nil, "", fmt.Errorf("parse manifest list error %v", err)
+		}
+		desc, err := selectPlatform(index, platform)
+		if err != nil {
+			return nil, "", err
+		}
+		if body, mediaType, err = c.FetchManifest(desc.Digest); err != nil {
+			return nil, "", err
+		}
+	}
+	if mediaType != MediaTypeOCIManifest && mediaType != MediaTypeDockerManifest {
+		return nil, "", fmt.Errorf("unsupported manifest media type %s", mediaType)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return nil, "", fmt.Errorf("parse manifest error %v", err)
+	}
+	return manifest, Digest(body), nil
+}
+
+func selectPlatform(index *Index, platform Platform) (*Descriptor, error) {
+	for i := range index.Manifests {
+		p := index.Manifests[i].Platform
+		if p == nil || p.OS != platform.OS || p.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && p.Variant != platform.Variant {
+			continue
+		}
+		return &index.Manifests[i], nil
+	}
+	return nil, fmt.Errorf("no matching manifest for %s/%s in the manifest list", platform.OS, platform.Architecture)
+}
+
+func manifestMediaType(body []byte, contentType string) string {
+	var probe struct {
+		MediaType string          `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &probe); err == nil && probe.MediaType != "" {
+		return probe.MediaType
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType != "application/json" {
+		return mediaType
+	}
+	// OCI允许manifest中不写mediaType，只能根据内容判断
+	if probe.Manifests != nil {
+		return MediaTypeOCIIndex
+	}
+	return MediaTypeOCIManifest
+}
+
+// 下载blob的内容，用于镜像配置这样的小文件
+func (c *Client) ReadBlob(desc Descriptor) ([]byte, error) {
+	resp, err := c.getBlob(desc.Digest, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, desc.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) != desc.Size || Digest(body) != desc.Digest {
+		return nil, fmt.Errorf("blob %s digest mismatch", desc.Digest)
+	}
+	return body, nil
+}
+
+// 把blob下载到dest，下载中的数据保存在 dest.partial 中，
+// 下次下载同一个blob时从中断的位置继续，下载完成后校验大小和digest
+func (c *Client) FetchBlob(desc Descriptor, dest string) error {
+	partialURL := dest + ".partial"
+	file, err := os.OpenFile(partialURL, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	offset, err := io.Copy(hasher, file)
+	if err != nil {
+		return err
+	}
+	if offset > desc.Size {
+		if offset, err = restartDownload(file, hasher); err != nil {
+			return err
+		}
+	}
+	if offset < desc.Size {
+		if err := c.downloadBlob(desc, file, hasher, offset); err != nil {
+			return err
+		}
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	digest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	if size != desc.Size || digest != desc.Digest {
+		os.Remove(partialURL)
+		return fmt.Errorf("blob %s digest mismatch, got %s with size %d", desc.Digest, digest, size)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partialURL, dest)
+}
+
+func (c *Client) downloadBlob(desc Descriptor, file *os.File, hasher hash.Hash, offset int64) error {
+	resp, err := c.getBlob(desc.Digest, offset)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return fmt.Errorf("unexpected content range %q", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		// registry不支持Range，只能从头下载
+		if offset, err = restartDownload(file, hasher); err != nil {
+			return err
+		}
+	default:
+		return checkResponse(resp, http.StatusOK)
+	}
+	// 多读一个字节，用来发现比预期大的blob
+	_, err = io.Copy(io.MultiWriter(file, hasher), io.LimitReader(resp.Body, desc.Size-offset+1))
+	return err
+}
+
+func restartDownload(file *os.File, hasher hash.Hash) (int64, error) {
+	hasher.Reset()
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	return file.Seek(0, io.SeekStart)
+}
+
+func (c *Client) getBlob(digest string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("/blobs/%s", digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return c.do(req)
+}
+
+func (c *Client) BlobExists(digest string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.url("/blobs/%s", digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("HEAD %s: unexpected status %s", req.URL, resp.Status)
+}
+
+// 上传blob：先POST申请一个上传地址，再把内容一次性PUT上去
+func (c *Client) PushBlob(desc Descriptor, r io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, c.url("/blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if err := checkResponse(resp, http.StatusAccepted); err != nil {
+		return err
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("invalid upload location %v", err)
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+
+	req, err = http.NewRequest(http.MethodPut, location.String(), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = desc.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusCreated)
+}
+
+// 上传manifest并打上tag，返回manifest的digest
+func (c *Client) PushManifest(tag string, manifest *Manifest) (string, error) {
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPut, c.url("/manifests/%s", tag), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", manifest.MediaType)
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusCreated); err != nil {
+		return "", err
+	}
+	return Digest(body), nil
+}
+
+// 发送请求，registry返回401时按WWW-Authenticate获取bearer token后重试一次
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := c.authorize(challenge); err != nil {
+		return nil, fmt.Errorf("%s %s: %v", req.Method, req.URL, err)
+	}
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("%s %s: unauthorized", req.Method, req.URL)
+		}
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+c.token)
+	return c.client.Do(retry)
+}
+
+func (c *Client) authorize(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return fmt.Errorf("invalid auth realm %v", err)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	resp, err := c.client.Get(realm.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return err
+	}
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return fmt.Errorf("decode token error %v", err)
+	}
+	c.token = tokenResp.Token
+	if c.token == "" {
+		c.token = tokenResp.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("empty token from %s", realm.Host)
+	}
+	return nil
+}
+
+// 解析 Bearer realm="...",service="...",scope="repository:x:pull,push"
+// 引号中的值可能包含逗号
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i == -1 {
+		return header, params
+	}
+	scheme, rest := header[:i], header[i+1:]
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			j := 1
+			for ; j < len(rest) && rest[j] != '"'; j++ {
+				if rest[j] == '\\' && j+1 < len(rest) {
+					j++
+				}
+				value.WriteByte(rest[j])
+			}
+			// 跳过结尾的引号，没有结尾的引号时已经到了末尾
+			if j < len(rest) {
+				j++
+			}
+			rest = rest[j:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end == -1 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			rest = rest[end:]
+		}
+		params[key] = value.String()
+	}
+	return scheme, params
+}
+
+// 检查响应状态码，出错时带上registry返回的错误信息
+func checkResponse(resp *http.Response, expected int) error {
+	if resp.StatusCode == expected {
+		return nil
+	}
+	var errResp struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := json.Unmarshal(body, &errResp); err == nil && len(errResp.Errors) > 0 {
+		var messages []string
+		for _, e := range errResp.Errors {
+			messages = append(messages, e.Code+": "+e.Message)
+		}
+		return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, strings.Join(messages, "; "))
+	}
+	return fmt.Errorf("%s %s: unexpected status %s", resp.Request.Method, resp.Request.URL, resp.Status)
+}
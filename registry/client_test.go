@@ -0,0 +1,231 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 模拟registry:2的最小实现，所有请求都需要bearer token
+type testRegistry struct {
+	mu        sync.Mutex
+	server    *httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	types     map[string]string
+	ranges    []string
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		blobs:     map[string][]byte{},
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testRegistry) client() *Client {
+	return NewClient(strings.TrimPrefix(r.server.URL, "http://"), "test/app", true)
+}
+
+func (r *testRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret-" + req.URL.Query().Get("scope")})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer secret-repository:test/app:pull,push" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:test/app:pull,push"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/test/app")
+	switch {
+	case req.Method == http.MethodPost && path == "/blobs/uploads/":
+		w.Header().Set("Location", "/v2/test/app/blobs/uploads/1?state=x")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && strings.HasPrefix(path, "/blobs/uploads/"):
+		body, _ := ioutil.ReadAll(req.Body)
+		digest := req.URL.Query().Get("digest")
+		if Digest(body) != digest || req.URL.Query().Get("state") != "x" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[digest] = body
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "/blobs/"):
+		blob, ok := r.blobs[strings.TrimPrefix(path, "/blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if rng := req.Header.Get("Range"); rng != "" {
+			r.ranges = append(r.ranges, rng)
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(blob))
+	case req.Method == http.MethodPut && strings.HasPrefix(path, "/manifests/"):
+		body, _ := ioutil.ReadAll(req.Body)
+		r.putManifest(strings.TrimPrefix(path, "/manifests/"), req.Header.Get("Content-Type"), body)
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "/manifests/"):
+		reference := strings.TrimPrefix(path, "/manifests/")
+		body, ok := r.manifests[reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[reference])
+		w.Write(body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *testRegistry) putManifest(tag string, mediaType string, body []byte) {
+	for _, reference := range []string{tag, Digest(body)} {
+		r.manifests[reference] = body
+		r.types[reference] = mediaType
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := registry.client()
+
+	layer := []byte("layer content")
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        Descriptor{MediaType: MediaTypeOCIConfig, Digest: Digest(config), Size: int64(len(config))},
+		Layers:        []Descriptor{{MediaType: MediaTypeOCILayer, Digest: Digest(layer), Size: int64(len(layer))}},
+	}
+	for _, blob := range [][]byte{layer, config} {
+		if err := client.PushBlob(Descriptor{Digest: Digest(blob), Size: int64(len(blob))}, bytes.NewReader(blob)); err != nil {
+			t.Fatalf("push blob error: %v", err)
+		}
+	}
+	if exists, err := client.BlobExists(Digest(layer)); err != nil || !exists {
+		t.Fatalf("expect pushed blob to exist, got %v %v", exists, err)
+	}
+	manifestDigest, err := client.PushManifest("v1", manifest)
+	if err != nil {
+		t.Fatalf("push manifest error: %v", err)
+	}
+
+	// 多平台镜像的manifest列表，只有linux/amd64指向真正的manifest
+	index, _ := json.Marshal(&Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeDockerManifestList,
+		Manifests: []Descriptor{
+			{Digest: Digest([]byte("arm")), Platform: &Platform{OS: "linux", Architecture: "arm64"}},
+			{Digest: manifestDigest, Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+		},
+	})
+	registry.putManifest("multi", MediaTypeDockerManifestList, index)
+
+	pulled, digest, err := client.PullManifest("multi", Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("pull manifest error: %v", err)
+	}
+	if digest != manifestDigest || pulled.Config.Digest != Digest(config) || len(pulled.Layers) != 1 {
+		t.Fatalf("unexpected manifest %s %+v", digest, pulled)
+	}
+	if _, _, err := client.PullManifest("multi", Platform{OS: "linux", Architecture: "s390x"}); err == nil {
+		t.Errorf("expect error for missing platform")
+	}
+	if _, _, err := client.PullManifest("missing", DefaultPlatform); err == nil || !strings.Contains(err.Error(), "MANIFEST_UNKNOWN") {
+		t.Errorf("expect manifest unknown error, got %v", err)
+	}
+
+	content, err := client.ReadBlob(pulled.Config)
+	if err != nil || !bytes.Equal(content, config) {
+		t.Fatalf("read config blob got %q %v", content, err)
+	}
+	dest := filepath.Join(t.TempDir(), "layer")
+	if err := client.FetchBlob(pulled.Layers[0], dest); err != nil {
+		t.Fatalf("fetch blob error: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(dest); !bytes.Equal(content, layer) {
+		t.Errorf("unexpected layer content %q", content)
+	}
+}
+
+func TestFetchBlobResume(t *testing.T) {
+	registry := newTestRegistry(t)
+	blob := bytes.Repeat([]byte("0123456789"), 100)
+	registry.blobs[Digest(blob)] = blob
+	desc := Descriptor{Digest: Digest(blob), Size: int64(len(blob))}
+
+	dest := filepath.Join(t.TempDir(), "blob")
+	if err := ioutil.WriteFile(dest+".partial", blob[:300], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.client().FetchBlob(desc, dest); err != nil {
+		t.Fatalf("fetch blob error: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(dest); !bytes.Equal(content, blob) {
+		t.Errorf("resumed blob content mismatch")
+	}
+	if len(registry.ranges) != 1 || registry.ranges[0] != "bytes=300-" {
+		t.Errorf("expect one range request from 300, got %v", registry.ranges)
+	}
+	if _, err := os.Stat(dest + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("expect partial file to be removed")
+	}
+}
+
+func TestFetchBlobDigestMismatch(t *testing.T) {
+	registry := newTestRegistry(t)
+	blob := []byte("tampered")
+	digest := Digest([]byte("original"))
+	registry.blobs[digest] = blob
+
+	dest := filepath.Join(t.TempDir(), "blob")
+	err := registry.client().FetchBlob(Descriptor{Digest: digest, Size: int64(len(blob))}, dest)
+	if err == nil {
+		t.Fatalf("expect digest mismatch error")
+	}
+	for _, path := range []string{dest, dest + ".partial"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expect %s to be removed", path)
+		}
+	}
+}
+
+func TestSplitName(t *testing.T) {
+	for name, expected := range map[string][2]string{
+		"busybox":                  {"docker.io", "library/busybox"},
+		"user/app":                 {"docker.io", "user/app"},
+		"localhost/app":            {"localhost", "app"},
+		"localhost:5000/team/app":  {"localhost:5000", "team/app"},
+		"registry.example.com/app": {"registry.example.com", "app"},
+	} {
+		domain, repository := SplitName(name)
+		if domain != expected[0] || repository != expected[1] {
+			t.Errorf("split %s: expect %v, got %s %s", name, expected, domain, repository)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:app:pull,push"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.example.com/token" ||
+		params["service"] != "registry" || params["scope"] != "repository:app:pull,push" {
+		t.Errorf("unexpected challenge %s %v", scheme, params)
+	}
+}
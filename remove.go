@@ -1,6 +1,7 @@
 package main
 
 import (
+	"example/mydocker/cgroups"
 	"example/mydocker/container"
 
 	log "github.com/sirupsen/logrus"
@@ -17,6 +18,11 @@ func RemoveContainer(containerName string) {
 		log.Errorf("cann't remove running container")
 		return
 	}
+	// rootfs还被占用时保留容器信息，之后可以再次删除
+	if err := container.DeleteWorkSpace(containerName); err != nil {
+		log.Errorf("remove container %s error %v", containerName, err)
+		return
+	}
+	cgroups.NewCgroupManager(containerCgroup(containerInfo.Id)).Remove()
 	deleteContainerInfo(containerName)
-	container.DeleteWorkSpace(containerInfo.Volume, containerName)
 }
\ No newline at end of file
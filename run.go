@@ -5,58 +5,145 @@ import (
 	"example/mydocker/cgroups"
 	"example/mydocker/cgroups/subsystems"
 	"example/mydocker/container"
+	"example/mydocker/logger"
 	"example/mydocker/network"
+	"example/mydocker/paths"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func Run(tty bool, command []string, res *subsystems.ResourceConfig, volume string, containerName string, imageName string, environment []string, nw string, portMapping []string) {
+// 所有容器的cgroup所在的目录，每个容器在其中有自己的cgroup，资源限制和设备规则互不影响
+const cgroupName = "mydocker-cgroup"
+
+// 容器自己的cgroup，用容器id命名，同名的容器删除后再创建也不会用到旧的cgroup
+func containerCgroup(containerID string) string {
+	return path.Join(cgroupName, containerID)
+}
+
+func Run(tty bool, interactive bool, detach bool, initConfig *container.InitConfig, res *subsystems.ResourceConfig, containerName string, imageID string, storageOpts *container.StorageOptions, logConfig *logger.Config, environment []string, nw string, portMapping []string) {
 	containerID := randStringBytes(10)
 	if containerName == "" {
 		log.Info("name is empty, use id")
 		containerName = containerID
 	}
-	parent, writePipe := container.NewParentProcess(tty, volume, containerName, imageName, environment)
-	if parent == nil {
-		log.Errorf("New parent process error")
+	// 启动完成之前，状态目录里还没有信息文件，持有锁防止被prune删掉
+	lock, err := lockPrune(false)
+	if err != nil {
+		log.Errorf("lock error %v", err)
+		return
+	}
+	defer lock.Close()
+	// 先占用容器名，后面失败时清理的状态目录和读写层都是这次创建的，不会删掉同名容器的
+	if err := reserveContainerName(containerName); err != nil {
+		log.Errorf("reserve container name error %v", err)
 		return
 	}
-	if err := parent.Start(); err != nil {
-		log.Fatal(err)
+	parent, writePipe, err := container.NewParentProcess(tty, initConfig.Mounts, containerName, imageID, environment, storageOpts)
+	if err != nil {
+		log.Errorf("New parent process error %v", err)
+		deleteContainerInfo(containerName)
+		return
+	}
+	cgroupManager := cgroups.NewCgroupManager(containerCgroup(containerID))
+	// 占用容器名之后的失败都在这里清理：关闭管道让init进程退出，等它退出后删掉cgroup、读写层和状态目录
+	cleanup := func() {
+		writePipe.Close()
+		if parent.Process != nil {
+			parent.Wait()
+		}
+		cgroupManager.Remove()
+		if err := container.DeleteWorkSpace(containerName); err != nil {
+			log.Errorf("delete workspace error %v", err)
+		}
+		deleteContainerInfo(containerName)
+	}
+	// 容器的标准输入输出都由supervisor持有，输出写到日志中，mydocker退出后也不会丢失
+	// 没有-d时，-ti和-i的容器由mydocker连接supervisor的attach socket和用户交互
+	attached := !detach && (tty || interactive)
+	closeStdio, err := container.StartSupervisor(parent, containerID, containerName, logConfig, tty, tty || interactive)
+	if err != nil {
+		log.Errorf("start supervisor error %v", err)
+		cleanup()
+		return
+	}
+	// 在容器启动之前连上supervisor，才不会错过容器最开始的输出
+	var conn *net.UnixConn
+	if attached {
+		if conn, err = container.DialAttach(containerName); err != nil {
+			log.Errorf("attach container error %v", err)
+			// 关闭容器一端的管道，supervisor读到EOF后自己退出
+			closeStdio()
+			cleanup()
+			return
+		}
+	}
+	err = parent.Start()
+	// 不管启动是否成功，父进程中容器一端的管道都要关闭
+	closeStdio()
+	if err != nil {
+		log.Errorf("start container process error %v", err)
+		cleanup()
+		os.Exit(1)
 	}
 
-	oneCommand := strings.Join(command, " ")
-	containerInfo, err := recordContainerInfo(parent.Process.Pid, containerID, oneCommand, containerName, volume, portMapping)
-	if containerInfo == nil || err != nil {
+	oneCommand := strings.Join(initConfig.Args, " ")
+	containerInfo, err := recordContainerInfo(parent.Process.Pid, containerID, oneCommand, containerName, initConfig.Mounts, imageID, portMapping, logConfig, tty, tty || interactive, initConfig.ReadOnlyRoot)
+	if err != nil {
 		log.Errorf("record container info error %v", err)
-		return
+		cleanup()
+		os.Exit(1)
 	}
 	if nw != "" {
 		network.Init()
 		if err := network.Connect(nw,containerInfo); err != nil {
 			log.Errorf("connect network failed: %v",err)
-			return
+			cleanup()
+			os.Exit(1)
+		}
+		if err := saveContainerInfo(containerInfo); err != nil {
+			log.Errorf("record container network error %v", err)
 		}
 	}
 	// 执行闪退，发现是这里的问题，后面发现是flag里面的mem参数没有传进来导致的
-	cgroupManager := cgroups.NewCgroupManager("mydocker-cgroup")
-	defer cgroupManager.Remove()
-	cgroupManager.Set(res)
-	cgroupManager.Apply(parent.Process.Pid)
-	sendInitCommand(oneCommand, writePipe)
-	// 只有当交互式时父进程会等待子进程结束
-	if tty {
+	// 限制资源失败时不能让容器继续启动，关闭管道后init进程读不到参数会自己退出
+	err = cgroups.CheckRequired(res, cgroupManager.Set(res), cgroupManager.Apply(parent.Process.Pid))
+	if err == nil {
+		err = sendInitCommand(parent, initConfig, writePipe)
+	}
+	if err != nil {
+		log.Errorf("start container error %v", err)
+		cleanup()
+		os.Exit(1)
+	}
+	lock.Close()
+	// 只有当交互式时父进程会等待子进程结束，用户按Ctrl-P Ctrl-Q分离后容器继续在后台运行
+	if attached {
+		detached, err := container.Attach(conn, tty, true)
+		if err != nil {
+			log.Errorf("attach container error %v", err)
+		}
+		if detached {
+			os.Exit(0)
+		}
 		parent.Wait()
+		cgroupManager.Remove()
 		deleteContainerInfo(containerInfo.Name)
 		// run()才是程序的main函数，所以要想确保在程序执行的最后销毁东西，写在这里比较好
-		container.DeleteWorkSpace(volume, containerInfo.Name)
+		if err := container.DeleteWorkSpace(containerInfo.Name); err != nil {
+			log.Errorf("delete workspace error %v", err)
+		}
 	}else {
 		log.Debug("-d模式,容器pid: ",parent.Process.Pid)
 		// 判断容器进程是否存活,用于detach失败的情况
@@ -81,15 +168,42 @@ func isProcessDefunct(pid int) bool {
     return strings.Contains(string(out), "defunct")
 }
 
-func sendInitCommand(oneCommand string, writePipe *os.File) {
-	log.Infof("command all is %s", oneCommand)
+// 把启动参数发给init进程，并等待init进程exec用户程序，init启动失败时返回它发回的错误
+func sendInitCommand(parent *exec.Cmd, initConfig *container.InitConfig, writePipe *os.File) error {
+	log.Infof("command all is %s", strings.Join(initConfig.Args, " "))
+	return sendConfig(parent, initConfig, writePipe)
+}
+
+// 通过管道把json格式的参数发给子进程，子进程exec之后管道关闭，返回子进程在此之前发回的错误
+func sendConfig(parent *exec.Cmd, config interface{}, writePipe *os.File) error {
+	defer writePipe.Close()
+	// 关闭父进程中子进程那一端管道的副本，否则子进程exec之后这里读不到EOF
+	for _, file := range parent.ExtraFiles {
+		file.Close()
+	}
+	jsonBytes, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("marshal config error %v", err)
+	}
 	// time.Sleep(3 * time.Second)
-	writePipe.WriteString(oneCommand)
-	// fmt.Println("结束writePipe")
-	writePipe.Close()
+	if _, err := writePipe.Write(jsonBytes); err != nil {
+		return err
+	}
+	// 关闭写端，子进程读到EOF后开始执行
+	if err := syscall.Shutdown(int(writePipe.Fd()), syscall.SHUT_WR); err != nil {
+		return err
+	}
+	reply, err := ioutil.ReadAll(writePipe)
+	if err != nil {
+		return err
+	}
+	if len(reply) > 0 {
+		return fmt.Errorf("%s", reply)
+	}
+	return nil
 }
 
-func recordContainerInfo(containerPID int, containerID string, oneCommand string, containerName string, volume string,portMapping []string) (*container.ContainerInfo, error) {
+func recordContainerInfo(containerPID int, containerID string, oneCommand string, containerName string, mounts []container.Mount, imageID string, portMapping []string, logConfig *logger.Config, tty bool, openStdin bool, readOnlyRoot bool) (*container.ContainerInfo, error) {
 	createTime := time.Now().Format("2006-01-02 15:04:05")
 	containerInfo := &container.ContainerInfo{
 		Id:         containerID,
@@ -98,8 +212,14 @@ func recordContainerInfo(containerPID int, containerID string, oneCommand string
 		Command:    oneCommand,
 		CreateTime: createTime,
 		Status:     container.Running,
-		Volume:     volume,
+		Mounts:     mounts,
+		Image:      imageID,
 		PortMapping: portMapping,
+		StorageDriver: paths.StorageDriver(),
+		LogConfig: logConfig,
+		Tty:       tty,
+		OpenStdin: openStdin,
+		ReadOnlyRoot: readOnlyRoot,
 	}
 
 	jsonBytes, err := json.Marshal(containerInfo)
@@ -110,12 +230,12 @@ func recordContainerInfo(containerPID int, containerID string, oneCommand string
 	jsonStr := string(jsonBytes)
 
 	// 数据已准备好，开始创建目录
-	configPath := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	configPath := paths.ContainerUrl(containerName)
 	if err := os.MkdirAll(configPath, 0622); err != nil {
 		log.Errorf("mkdir configPath:%s error %v", configPath, err)
 		return nil, err
 	}
-	fileName := configPath + "/" + container.ConfigName
+	fileName := filepath.Join(configPath, container.ConfigName)
 	file, err := os.Create(fileName)
 	if err != nil {
 		log.Errorf("create config file:%s error %v", fileName, err)
@@ -142,8 +262,28 @@ func randStringBytes(n int) string {
 	return string(b)
 }
 
+// 创建容器的状态目录来占用容器名，供supervisor和recordContainerInfo使用
+// 目录已经存在时，有信息文件的是同名容器，没有的是正在启动的容器或者启动失败时留下的
+func reserveContainerName(containerName string) error {
+	if err := os.MkdirAll(paths.ContainersUrl(), 0622); err != nil {
+		return fmt.Errorf("mkdir %s error %v", paths.ContainersUrl(), err)
+	}
+	configPath := paths.ContainerUrl(containerName)
+	err := os.Mkdir(configPath, 0622)
+	if err == nil {
+		return nil
+	}
+	if !os.IsExist(err) {
+		return fmt.Errorf("mkdir configPath:%s error %v", configPath, err)
+	}
+	if _, err := os.Stat(filepath.Join(configPath, container.ConfigName)); err == nil {
+		return fmt.Errorf("container name %s is already in use", containerName)
+	}
+	return fmt.Errorf("container name %s is already in use by a container being created, or left by a failed run which mydocker system prune can clean up", containerName)
+}
+
 func deleteContainerInfo(containerName string) {
-	configPath := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	configPath := paths.ContainerUrl(containerName)
 	if err := os.RemoveAll(configPath); err != nil {
 		log.Errorf("remove configPath:%s error %v", configPath, err)
 	}
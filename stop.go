@@ -1,10 +1,7 @@
 package main
 
 import (
-	"encoding/json"
 	"example/mydocker/container"
-	"fmt"
-	"io/ioutil"
 	"strconv"
 	"syscall"
 
@@ -34,16 +31,8 @@ func StopContainer(containerName string) {
 	}
 	containerInfo.Status = container.Stop
 	containerInfo.Pid = ""
-	jsonBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		log.Errorf("record container info error %v", err) 
-		return
-	}
-	
-	configPath := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	configPath = configPath + container.ConfigName
-	if err := ioutil.WriteFile(configPath, jsonBytes, 0622); err != nil {
+	if err := saveContainerInfo(containerInfo); err != nil {
 		log.Errorf("update config fail %v", err)
 		return
 	}
-}
\ No newline at end of file
+}
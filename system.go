@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"example/mydocker/cgroups"
+	"example/mydocker/container"
+	"example/mydocker/network"
+	"example/mydocker/paths"
+	"example/mydocker/units"
+	"example/mydocker/volume"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// run和build创建还没有信息文件的状态目录和workspace期间持有共享锁，prune持有排它锁，
+// 不会把正在创建的容器当成崩溃的run留下的资源删掉；关闭返回的文件即释放锁，进程退出时也会释放
+func lockPrune(exclusive bool) (*os.File, error) {
+	if err := os.MkdirAll(paths.StateDir(), 0755); err != nil {
+		return nil, fmt.Errorf("mkdir %s error %v", paths.StateDir(), err)
+	}
+	lockPath := paths.PruneLockUrl()
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open %s error %v", lockPath, err)
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("lock %s error %v", lockPath, err)
+	}
+	return file, nil
+}
+
+// 容器进程是否还在运行，进程已经退出但状态还是running的容器不算
+func containerAlive(info *container.ContainerInfo) bool {
+	if info.Status != container.Running {
+		return false
+	}
+	pid, err := strconv.Atoi(info.Pid)
+	if err != nil || pid <= 0 {
+		return false
+	}
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+	// 已经退出还没有被回收的进程是僵尸进程，stat中的状态是Z
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	i := bytes.LastIndexByte(stat, ')')
+	return i < 0 || i+2 >= len(stat) || stat[i+2] != 'Z'
+}
+
+// 清理崩溃的run留下的资源：没有容器信息的workspace和状态目录、没有进程的cgroup，
+// 以及不属于运行中容器的ip、veth和端口映射规则；dryRun时只列出要清理的资源
+// 正在启动的容器还没有信息文件，清理时持有排它锁，等它们启动完成或者失败清理之后再开始
+func PruneSystem(dryRun bool) error {
+	lock, err := lockPrune(true)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	containerInfos, err := getContainerInfos()
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	var running []*container.ContainerInfo
+	for _, info := range containerInfos {
+		known[info.Name] = true
+		if containerAlive(info) {
+			running = append(running, info)
+		}
+	}
+
+	action := "Removed"
+	if dryRun {
+		action = "Would remove"
+	}
+	failed := false
+	report := func(items []string, err error) {
+		for _, item := range items {
+			fmt.Printf("%s %s\n", action, item)
+		}
+		if err != nil {
+			log.Errorf("prune error %v", err)
+			failed = true
+		}
+	}
+
+	// 读不到信息文件的容器状态目录，如启动失败时留下的日志目录
+	stateDirs, err := subdirs(paths.ContainersUrl())
+	report(nil, err)
+	for _, name := range stateDirs {
+		if known[name] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(paths.ContainerUrl(name), container.ConfigName)); err == nil {
+			// 信息文件存在但解析失败，保留下来排查
+			continue
+		}
+		var removeErr error
+		if !dryRun {
+			removeErr = os.RemoveAll(paths.ContainerUrl(name))
+		}
+		report([]string{"container state " + name}, removeErr)
+	}
+
+	// 容器的rootfs挂载点、读写层、overlay的workdir和限制读写层大小的镜像
+	workspaces := map[string]bool{}
+	for _, dir := range []string{paths.MntsUrl(), paths.WriteLayersUrl(), paths.WorkLayersUrl(), paths.StoragesUrl()} {
+		names, err := subdirs(dir)
+		report(nil, err)
+		for _, name := range names {
+			workspaces[name] = true
+		}
+	}
+	for name := range workspaces {
+		if known[name] {
+			continue
+		}
+		var removeErr error
+		if !dryRun {
+			removeErr = container.DeleteWorkSpace(name)
+		}
+		report([]string{"workspace " + name}, removeErr)
+	}
+
+	cgroupPaths, err := cgroups.NewCgroupManager(cgroupName).Prune(dryRun)
+	for i := range cgroupPaths {
+		cgroupPaths[i] = "cgroup " + cgroupPaths[i]
+	}
+	report(cgroupPaths, err)
+	report(network.Prune(running, dryRun))
+	if failed {
+		return fmt.Errorf("some resources could not be pruned")
+	}
+	return nil
+}
+
+// 目录下的子目录名，目录不存在时返回空
+func subdirs(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+// 目录中文件的总大小，硬链接只计算一次
+func dirSize(dir string) (int64, error) {
+	var size int64
+	seen := map[uint64]bool{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+			if seen[stat.Ino] {
+				return nil
+			}
+			seen[stat.Ino] = true
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+type imageUsage struct {
+	id         string
+	references []string
+	created    string
+	size       int64
+	sharedSize int64
+	containers int
+}
+
+type containerUsage struct {
+	info   *container.ContainerInfo
+	size   int64
+	alive  bool
+	volume int
+}
+
+type volumeUsage struct {
+	name  string
+	links int
+	size  int64
+}
+
+// 统计镜像、容器读写层和命名卷占用的空间，verbose时列出每一项
+// 多个镜像共享的镜像层只计算一次，没有容器使用的镜像、没有运行的容器和没有容器挂载的卷是可以回收的
+func DiskUsage(verbose bool) error {
+	containerInfos, err := getContainerInfos()
+	if err != nil {
+		return err
+	}
+	imageContainers := map[string]int{}
+	volumeLinks := map[string]int{}
+	var containers []*containerUsage
+	for _, info := range containerInfos {
+		size, err := dirSize(paths.WriteLayerUrl(info.Name))
+		if err != nil {
+			return err
+		}
+		usage := &containerUsage{info: info, size: size, alive: containerAlive(info)}
+		for _, m := range info.Mounts {
+			if m.Type == container.MountTypeVolume {
+				volumeLinks[m.Name]++
+				usage.volume++
+			}
+		}
+		imageContainers[info.Image]++
+		containers = append(containers, usage)
+	}
+
+	images, layerSizes, err := imageUsages(imageContainers)
+	if err != nil {
+		return err
+	}
+	var imagesSize, imagesReclaimable int64
+	activeLayers := map[string]bool{}
+	activeImages := 0
+	for _, image := range images {
+		if image.containers > 0 {
+			activeImages++
+			config, err := container.ReadImageConfig(image.id)
+			if err != nil {
+				return err
+			}
+			for _, layer := range config.Layers {
+				activeLayers[layer] = true
+			}
+		}
+	}
+	for layer, size := range layerSizes {
+		imagesSize += size
+		if !activeLayers[layer] {
+			imagesReclaimable += size
+		}
+	}
+
+	var containersSize, containersReclaimable int64
+	activeContainers := 0
+	for _, c := range containers {
+		containersSize += c.size
+		if c.alive {
+			activeContainers++
+		} else {
+			containersReclaimable += c.size
+		}
+	}
+
+	vols, err := volume.List()
+	if err != nil {
+		return err
+	}
+	var volumes []*volumeUsage
+	var volumesSize, volumesReclaimable int64
+	activeVolumes := 0
+	for _, v := range vols {
+		size, err := dirSize(v.Mountpoint)
+		if err != nil {
+			return err
+		}
+		volumes = append(volumes, &volumeUsage{name: v.Name, links: volumeLinks[v.Name], size: size})
+		volumesSize += size
+		if volumeLinks[v.Name] > 0 {
+			activeVolumes++
+		} else {
+			volumesReclaimable += size
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if !verbose {
+		fmt.Fprint(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE\n")
+		fmt.Fprintf(w, "Images\t%d\t%d\t%s\t%s\n", len(images), activeImages, units.HumanSize(imagesSize), reclaimable(imagesReclaimable, imagesSize))
+		fmt.Fprintf(w, "Containers\t%d\t%d\t%s\t%s\n", len(containers), activeContainers, units.HumanSize(containersSize), reclaimable(containersReclaimable, containersSize))
+		fmt.Fprintf(w, "Local Volumes\t%d\t%d\t%s\t%s\n", len(volumes), activeVolumes, units.HumanSize(volumesSize), reclaimable(volumesReclaimable, volumesSize))
+		return w.Flush()
+	}
+
+	fmt.Fprint(w, "Images space usage:\n\n")
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\tSHARED SIZE\tUNIQUE SIZE\tCONTAINERS\n")
+	for _, image := range images {
+		references := image.references
+		if len(references) == 0 {
+			references = []string{""}
+		}
+		for _, name := range references {
+			repository, tag := "<none>", "<none>"
+			if ref, err := container.ParseReference(name); err == nil {
+				repository, tag = ref.Name, ref.Tag
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n", repository, tag, image.id[:12], image.created,
+				units.HumanSize(image.size), units.HumanSize(image.sharedSize), units.HumanSize(image.size-image.sharedSize), image.containers)
+		}
+	}
+	fmt.Fprint(w, "\nContainers space usage:\n\n")
+	fmt.Fprint(w, "CONTAINER ID\tIMAGE\tCOMMAND\tLOCAL VOLUMES\tSIZE\tCREATED\tSTATUS\tNAMES\n")
+	for _, c := range containers {
+		image := c.info.Image
+		if len(image) > 12 {
+			image = image[:12]
+		}
+		command := c.info.Command
+		if len(command) > 30 {
+			command = command[:27] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", c.info.Id, image, command, c.volume,
+			units.HumanSize(c.size), c.info.CreateTime, c.info.Status, c.info.Name)
+	}
+	fmt.Fprint(w, "\nLocal Volumes space usage:\n\n")
+	fmt.Fprint(w, "VOLUME NAME\tLINKS\tSIZE\n")
+	for _, v := range volumes {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", v.name, v.links, units.HumanSize(v.size))
+	}
+	return w.Flush()
+}
+
+// 统计每个镜像的大小，以及和其他镜像共享的镜像层的大小
+// 同时返回每个镜像层的大小
+func imageUsages(imageContainers map[string]int) ([]*imageUsage, map[string]int64, error) {
+	ids, err := container.ListImageIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+	repos, err := container.ListReferences()
+	if err != nil {
+		return nil, nil, err
+	}
+	references := map[string][]string{}
+	for name, id := range repos {
+		references[id] = append(references[id], name)
+	}
+
+	layerSizes := map[string]int64{}
+	layerImages := map[string][]string{}
+	configs := map[string]*container.ImageConfig{}
+	for _, id := range ids {
+		config, err := container.ReadImageConfig(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		configs[id] = config
+		for _, layer := range config.Layers {
+			if !containsString(layerImages[layer], id) {
+				layerImages[layer] = append(layerImages[layer], id)
+			}
+			if _, ok := layerSizes[layer]; ok {
+				continue
+			}
+			if layerSizes[layer], err = dirSize(paths.LayerUrl(layer)); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var images []*imageUsage
+	for _, id := range ids {
+		config := configs[id]
+		sort.Strings(references[id])
+		image := &imageUsage{id: id, references: references[id], created: config.Created, containers: imageContainers[id]}
+		counted := map[string]bool{}
+		for _, layer := range config.Layers {
+			if counted[layer] {
+				continue
+			}
+			counted[layer] = true
+			image.size += layerSizes[layer]
+			if len(layerImages[layer]) > 1 {
+				image.sharedSize += layerSizes[layer]
+			}
+		}
+		images = append(images, image)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].created > images[j].created })
+	return images, layerSizes, nil
+}
+
+// 可回收的大小和所占的比例，如 1.5MB (30%)
+func reclaimable(size int64, total int64) string {
+	if total == 0 {
+		return units.HumanSize(size)
+	}
+	return fmt.Sprintf("%s (%d%%)", units.HumanSize(size), size*100/total)
+}
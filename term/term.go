@@ -0,0 +1,64 @@
+package term
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// 切换到raw模式之前终端的设置，用于恢复
+type State struct {
+	termios unix.Termios
+}
+
+// 终端窗口的大小，单位是字符
+type Winsize struct {
+	Height uint16 `json:"height"`
+	Width  uint16 `json:"width"`
+}
+
+func IsTerminal(fd uintptr) bool {
+	_, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	return err == nil
+}
+
+// 把终端切换到raw模式，输入不再回显，也不由终端处理Ctrl-C等控制字符，原样交给读取的程序，
+// 设置和cfmakeraw相同；返回原来的设置，退出前用Restore恢复
+func MakeRaw(fd uintptr) (*State, error) {
+	termios, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{termios: *termios}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(int(fd), unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func Restore(fd uintptr, state *State) error {
+	return unix.IoctlSetTermios(int(fd), unix.TCSETS, &state.termios)
+}
+
+func GetWinsize(fd uintptr) (*Winsize, error) {
+	ws, err := unix.IoctlGetWinsize(int(fd), unix.TIOCGWINSZ)
+	if err != nil {
+		return nil, err
+	}
+	return &Winsize{Height: ws.Row, Width: ws.Col}, nil
+}
+
+// 设置终端的窗口大小，设置pty的master时，内核会给slave的前台进程组发送SIGWINCH
+func SetWinsize(fd uintptr, ws *Winsize) error {
+	return unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{Row: ws.Height, Col: ws.Width})
+}
+
+// 把终端设置为当前进程的控制终端，当前进程需要是还没有控制终端的会话首进程
+func SetControllingTerminal(fd uintptr) error {
+	return unix.IoctlSetInt(int(fd), unix.TIOCSCTTY, 0)
+}
@@ -0,0 +1,43 @@
+package term
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPty(t *testing.T) {
+	master, slave, err := OpenPty()
+	if err != nil {
+		t.Skipf("open pty: %v", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	if !IsTerminal(slave.Fd()) {
+		t.Fatal("slave is not a terminal")
+	}
+
+	if err := SetWinsize(master.Fd(), &Winsize{Height: 40, Width: 120}); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := GetWinsize(slave.Fd())
+	if err != nil || ws.Height != 40 || ws.Width != 120 {
+		t.Errorf("GetWinsize = %+v, %v, want 40x120", ws, err)
+	}
+
+	state, err := MakeRaw(slave.Fd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	termios, _ := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if termios.Lflag&(unix.ECHO|unix.ICANON|unix.ISIG) != 0 || termios.Oflag&unix.OPOST != 0 {
+		t.Errorf("raw mode termios lflag %#x oflag %#x", termios.Lflag, termios.Oflag)
+	}
+	if err := Restore(slave.Fd(), state); err != nil {
+		t.Fatal(err)
+	}
+	termios, _ = unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if termios.Lflag&unix.ICANON == 0 {
+		t.Error("terminal not restored")
+	}
+}
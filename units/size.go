@@ -0,0 +1,57 @@
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// 解析 64m、1.5g、512k 这样的大小，单位是1024进制，不区分大小写，可以带b后缀(如64mb)
+func ParseSize(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(str)
+	}
+	number, unit := str[:i], strings.TrimSpace(str[i:])
+	if len(unit) == 2 && unit[1] == 'b' {
+		unit = unit[:1]
+	}
+	multiplier, ok := sizeUnits[unit]
+	if !ok || number == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// 把字节数格式化成便于阅读的形式，如 1.5MB
+func HumanSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	// 不用%g，1000到1023之间的值会变成1e+03这样的指数形式
+	number := strings.TrimRight(strconv.FormatFloat(value, 'f', 2, 64), "0")
+	return strings.TrimSuffix(number, ".") + units[i]
+}
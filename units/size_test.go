@@ -0,0 +1,44 @@
+package units
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"1024": 1024,
+		"64m":  64 << 20,
+		"64MB": 64 << 20,
+		"1.5g": 3 << 29,
+		"512k": 512 << 10,
+		"10b":  10,
+	} {
+		size, err := ParseSize(s)
+		if err != nil || size != expected {
+			t.Errorf("parse %s: expect %d, got %d %v", s, expected, size, err)
+		}
+	}
+	for _, s := range []string{"", "m", "-1m", "64x", "1..5m"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("expect error for %q", s)
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	for size, expected := range map[int64]string{
+		100:              "100B",
+		1536:             "1.5KB",
+		64 << 20:         "64MB",
+		1000 << 10:       "1000KB",
+		1023 << 10:       "1023KB",
+		1023 << 20:       "1023MB",
+		1000 << 30:       "1000GB",
+		1023<<30 + 1<<29: "1023.5GB",
+		1023 << 40:       "1023TB",
+		1024 << 40:       "1024TB",
+		1234567:          "1.18MB",
+	} {
+		if s := HumanSize(size); s != expected {
+			t.Errorf("format %d: expect %s, got %s", size, expected, s)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// 解析日志过滤用的时间，可以是RFC3339格式的时间、unix时间戳(可以带小数)，
+// 或者10m、1h30m这样的时长，表示now之前的这段时间；不带时区的时间按本地时间解析
+func ParseTimestamp(s string, now time.Time) (time.Time, error) {
+	str := strings.TrimSpace(s)
+	if str == "" {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	// 和docker一样先按时长解析，1.5h这样带小数的时长不会被当成时间戳
+	if d, err := time.ParseDuration(str); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, ok := parseUnixTimestamp(str); ok {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
+
+// 解析unix时间戳，小数部分补齐或截断成9位就是纳秒数
+func parseUnixTimestamp(str string) (time.Time, bool) {
+	sec, frac, hasFrac := strings.Cut(str, ".")
+	if strings.HasPrefix(sec, "-") {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nanos int64
+	if hasFrac {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		n, err := strconv.ParseUint(frac, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		nanos = int64(n)
+	}
+	return time.Unix(seconds, nanos), true
+}
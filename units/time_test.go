@@ -0,0 +1,42 @@
+package units
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+		err  bool
+	}{
+		{in: "10m", want: now.Add(-10 * time.Minute)},
+		{in: "1h30m", want: now.Add(-90 * time.Minute)},
+		{in: "1.5h", want: now.Add(-90 * time.Minute)},
+		{in: "0.5s", want: now.Add(-500 * time.Millisecond)},
+		{in: "1700000000", want: time.Unix(1700000000, 0)},
+		{in: "1700000000.5", want: time.Unix(1700000000, 500000000)},
+		{in: "2024-05-06T01:02:03Z", want: time.Date(2024, 5, 6, 1, 2, 3, 0, time.UTC)},
+		{in: "2024-05-06T01:02:03.123456789+08:00", want: time.Date(2024, 5, 5, 17, 2, 3, 123456789, time.UTC)},
+		{in: "2024-05-06T01:02:03", want: time.Date(2024, 5, 6, 1, 2, 3, 0, time.Local)},
+		{in: "2024-05-06", want: time.Date(2024, 5, 6, 0, 0, 0, 0, time.Local)},
+		{in: "", err: true},
+		{in: "yesterday", err: true},
+		{in: "-10m", err: true},
+		{in: "1700000000.x", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in, now)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseTimestamp(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseTimestamp(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package volume
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"example/mydocker/paths"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	dataDir    = "_data"
+	configName = "volume.json"
+)
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// 命名卷，数据保存在数据目录的 volumes/卷名/_data 下，可以被多个容器挂载，删除容器时不会删除
+type Volume struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+func ValidName(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+// 创建命名卷，name为空时生成随机的名字，卷已经存在时直接返回
+func Create(name string) (*Volume, error) {
+	if name == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		name = hex.EncodeToString(b)
+	}
+	if !ValidName(name) {
+		return nil, fmt.Errorf("invalid volume name %s", name)
+	}
+	if v, err := Get(name); err == nil {
+		return v, nil
+	}
+	v := &Volume{
+		Name:       name,
+		Mountpoint: filepath.Join(paths.VolumeUrl(name), dataDir),
+		CreatedAt:  time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
+		return nil, err
+	}
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(paths.VolumeUrl(name), configName), jsonBytes, 0644); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func Get(name string) (*Volume, error) {
+	if !ValidName(name) {
+		return nil, fmt.Errorf("invalid volume name %s", name)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(paths.VolumeUrl(name), configName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such volume: %s", name)
+		}
+		return nil, err
+	}
+	v := &Volume{}
+	if err := json.Unmarshal(content, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// 返回所有的命名卷，按名字排序
+func List() ([]*Volume, error) {
+	files, err := ioutil.ReadDir(paths.VolumesUrl())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var volumes []*Volume
+	for _, file := range files {
+		v, err := Get(file.Name())
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// 删除命名卷和其中的数据，调用者需要确认没有容器在使用
+func Remove(name string) error {
+	if _, err := Get(name); err != nil {
+		return err
+	}
+	return os.RemoveAll(paths.VolumeUrl(name))
+}
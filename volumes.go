@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"example/mydocker/container"
+	"example/mydocker/volume"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func ListVolumes() {
+	volumes, err := volume.List()
+	if err != nil {
+		log.Errorf("list volumes error %v", err)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "VOLUME NAME\tMOUNTPOINT\tCREATED\n")
+	for _, v := range volumes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, v.Mountpoint, v.CreatedAt)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("flush tabwriter error %v", err)
+	}
+}
+
+// 删除命名卷，有容器(包括已经停止的容器)挂载了这个卷时需要force
+func RemoveVolume(name string, force bool) error {
+	if _, err := volume.Get(name); err != nil {
+		return err
+	}
+	users, err := volumeUsers(name)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 && !force {
+		return fmt.Errorf("volume %s is in use by container %s, use -f to remove it", name, strings.Join(users, ","))
+	}
+	if err := volume.Remove(name); err != nil {
+		return err
+	}
+	fmt.Println(name)
+	return nil
+}
+
+func volumeUsers(name string) ([]string, error) {
+	containerInfos, err := getContainerInfos()
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, info := range containerInfos {
+		for _, m := range info.Mounts {
+			if m.Type == container.MountTypeVolume && m.Name == name {
+				users = append(users, info.Name)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+func InspectVolumes(names []string) error {
+	var volumes []*volume.Volume
+	for _, name := range names {
+		v, err := volume.Get(name)
+		if err != nil {
+			return err
+		}
+		volumes = append(volumes, v)
+	}
+	jsonBytes, err := json.MarshalIndent(volumes, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}